@@ -0,0 +1,230 @@
+// Package apm abstracts application performance monitoring, so that request transactions and their
+// segments can be reported to New Relic, OpenTelemetry, or both while migrating between them.
+//
+// The transaction of a request is started by middleware.AddTracing and retrieved with FromContext,
+// which never returns nil: without a transaction, segments and errors are simply dropped.
+package apm
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/go-chi/chi"
+	newrelic "github.com/newrelic/go-agent"
+)
+
+const (
+	// ProductPostgres is the datastore product of PostgreSQL segments
+	ProductPostgres = "Postgres"
+
+	// AttributeStatusCode is the attribute carrying the response status of a transaction
+	AttributeStatusCode = "http.status_code"
+	// AttributeRequestID is the attribute carrying the request id, as logged in "rid"
+	AttributeRequestID = "rid"
+	// AttributeUserID and AttributeVisitorID carry the user and visitor ids of the JWT
+	AttributeUserID    = "uid"
+	AttributeVisitorID = "vid"
+	// AttributeLocale is the attribute carrying the locale of the route, e.g. "en-us"
+	AttributeLocale = "locale"
+
+	// UnmatchedRoute names the transactions of requests that matched no route, as metrics does
+	UnmatchedRoute = "unmatched"
+)
+
+// txnKey is the context key of the request Transaction
+const txnKey = contextKey("transaction")
+
+type contextKey string
+
+func (c contextKey) String() string {
+	return "apm context key " + string(c)
+}
+
+// Tracer starts the transactions of incoming requests
+type Tracer interface {
+	// StartTransaction starts the transaction of a request
+	//
+	// Return Values:
+	//     1st: The transaction, to be ended once the request is served
+	//     2nd: The request context carrying the transaction, see FromContext
+	StartTransaction(w http.ResponseWriter, r *http.Request) (Transaction, context.Context)
+	// StartBackgroundTransaction starts a transaction outside of any request, e.g. for a job
+	StartBackgroundTransaction(name string) Transaction
+	// Shutdown flushes pending data, waiting at most timeout
+	Shutdown(timeout time.Duration)
+}
+
+// Transaction is the monitored execution of a request
+type Transaction interface {
+	// SetName names the transaction, e.g. after the route pattern of the request
+	SetName(name string)
+	// AddAttribute adds an attribute to the transaction
+	AddAttribute(key string, value interface{})
+	// NoticeError records an error on the transaction
+	// Errors implementing newrelic.ErrorAttributer, such as newrelic.Error, carry their attributes.
+	NoticeError(err error)
+	// StartSegment starts timing a named part of the transaction
+	StartSegment(name string) Segment
+	// StartDatastoreSegment starts timing a datastore call
+	StartDatastoreSegment(s DatastoreSegment) Segment
+	// StartExternalSegment starts timing an outgoing request, adding the trace context propagation headers to it
+	StartExternalSegment(r *http.Request) Segment
+	// End completes the transaction
+	End()
+}
+
+// Segment is a timed part of a transaction
+type Segment interface {
+	End()
+}
+
+// DatastoreSegment describes a datastore call
+type DatastoreSegment struct {
+	// Product is the datastore, e.g. ProductPostgres
+	Product string
+	// Collection is the table or collection
+	Collection string
+	// Operation is the statement type, e.g. "select"
+	Operation string
+	// Query is the parameterized query, it must not contain any value as it is sent as is
+	Query    string
+	Host     string
+	Port     string
+	Database string
+}
+
+// NewContext returns a context carrying a transaction
+func NewContext(ctx context.Context, txn Transaction) context.Context {
+	return context.WithValue(ctx, txnKey, txn)
+}
+
+// FromContext returns the transaction of a context, or a transaction discarding everything when there is none
+// A New Relic transaction stored under utils.ContextKey("txn"), as AddProfiling used to, is supported too.
+func FromContext(ctx context.Context) Transaction {
+	if txn, ok := ctx.Value(txnKey).(Transaction); ok && txn != nil {
+		return txn
+	}
+	if txn, ok := ctx.Value(utils.ContextKey("txn")).(newrelic.Transaction); ok && txn != nil {
+		return &newRelicTransaction{txn: txn}
+	}
+	return noopTransaction{}
+}
+
+// RouteName names a transaction after the method and the chi route pattern of its request, e.g.
+// "GET /users/{id}", so that every user shares the same transaction
+// The pattern is only complete once the request is routed, and is UnmatchedRoute when none matched.
+func RouteName(r *http.Request) string {
+	pattern := UnmatchedRoute
+	if rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context); ok && rctx != nil {
+		if p := rctx.RoutePattern(); p != "" {
+			pattern = p
+		}
+	}
+	return r.Method + " " + pattern
+}
+
+// noopTransaction discards everything
+type noopTransaction struct{}
+
+func (noopTransaction) SetName(string)                                 {}
+func (noopTransaction) AddAttribute(string, interface{})               {}
+func (noopTransaction) NoticeError(error)                              {}
+func (noopTransaction) StartSegment(string) Segment                    { return noopSegment{} }
+func (noopTransaction) StartDatastoreSegment(DatastoreSegment) Segment { return noopSegment{} }
+func (noopTransaction) StartExternalSegment(*http.Request) Segment     { return noopSegment{} }
+func (noopTransaction) End()                                           {}
+
+type noopSegment struct{}
+
+func (noopSegment) End() {}
+
+// Multi returns a Tracer reporting to all the given tracers, e.g. New Relic and OpenTelemetry while migrating
+func Multi(tracers ...Tracer) Tracer {
+	return multiTracer(tracers)
+}
+
+type multiTracer []Tracer
+
+func (m multiTracer) StartTransaction(w http.ResponseWriter, r *http.Request) (Transaction, context.Context) {
+	txns := make(multiTransaction, len(m))
+	for i, tracer := range m {
+		var ctx context.Context
+		txns[i], ctx = tracer.StartTransaction(w, r)
+		r = r.WithContext(ctx)
+	}
+	return txns, NewContext(r.Context(), txns)
+}
+
+func (m multiTracer) StartBackgroundTransaction(name string) Transaction {
+	txns := make(multiTransaction, len(m))
+	for i, tracer := range m {
+		txns[i] = tracer.StartBackgroundTransaction(name)
+	}
+	return txns
+}
+
+func (m multiTracer) Shutdown(timeout time.Duration) {
+	for _, tracer := range m {
+		tracer.Shutdown(timeout)
+	}
+}
+
+type multiTransaction []Transaction
+
+func (m multiTransaction) SetName(name string) {
+	for _, txn := range m {
+		txn.SetName(name)
+	}
+}
+
+func (m multiTransaction) AddAttribute(key string, value interface{}) {
+	for _, txn := range m {
+		txn.AddAttribute(key, value)
+	}
+}
+
+func (m multiTransaction) NoticeError(err error) {
+	for _, txn := range m {
+		txn.NoticeError(err)
+	}
+}
+
+func (m multiTransaction) StartSegment(name string) Segment {
+	segments := make(multiSegment, len(m))
+	for i, txn := range m {
+		segments[i] = txn.StartSegment(name)
+	}
+	return segments
+}
+
+func (m multiTransaction) StartDatastoreSegment(s DatastoreSegment) Segment {
+	segments := make(multiSegment, len(m))
+	for i, txn := range m {
+		segments[i] = txn.StartDatastoreSegment(s)
+	}
+	return segments
+}
+
+func (m multiTransaction) StartExternalSegment(r *http.Request) Segment {
+	segments := make(multiSegment, len(m))
+	for i, txn := range m {
+		segments[i] = txn.StartExternalSegment(r)
+	}
+	return segments
+}
+
+func (m multiTransaction) End() {
+	for _, txn := range m {
+		txn.End()
+	}
+}
+
+type multiSegment []Segment
+
+func (m multiSegment) End() {
+	for _, s := range m {
+		s.End()
+	}
+}
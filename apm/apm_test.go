@@ -0,0 +1,203 @@
+package apm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/go-chi/chi"
+	newrelic "github.com/newrelic/go-agent"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newRecorder() (*tracetest.SpanRecorder, Tracer) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return recorder, OpenTelemetry(provider)
+}
+
+// serve routes a request through chi with the tracer started the way middleware.AddTracing does
+func serve(tracer Tracer, pattern string, handler http.HandlerFunc, r *http.Request) {
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			txn, ctx := tracer.StartTransaction(w, r)
+			defer txn.End()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	router.Get(pattern, handler)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, noopTransaction{}, FromContext(context.Background()))
+
+	txn := noopTransaction{}
+	assert.Equal(t, txn, FromContext(NewContext(context.Background(), txn)))
+
+	app, err := newrelic.NewApplication(newrelic.Config{AppName: "apm-test", Enabled: false})
+	assert.NoError(t, err)
+	nrTxn := app.StartTransaction("test", nil, nil)
+	legacy := FromContext(context.WithValue(context.Background(), utils.ContextKey("txn"), nrTxn))
+	unwrapped, ok := NewRelicTransaction(legacy)
+	assert.True(t, ok)
+	assert.True(t, unwrapped == nrTxn)
+
+	// segments of a context without a transaction are dropped
+	FromContext(context.Background()).StartDatastoreSegment(DatastoreSegment{Product: ProductPostgres}).End()
+}
+
+func TestOpenTelemetryTransaction(t *testing.T) {
+	recorder, tracer := newRecorder()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	var outgoing *http.Request
+	serve(tracer, "/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		txn := FromContext(r.Context())
+		txn.AddAttribute(AttributeStatusCode, http.StatusServiceUnavailable)
+
+		FromContext(r.Context()).StartDatastoreSegment(DatastoreSegment{
+			Product: ProductPostgres, Collection: "users", Operation: "select", Query: "SELECT 1",
+		}).End()
+
+		outgoing = httptest.NewRequest(http.MethodGet, "http://gws.local/classes", nil)
+		txn.StartExternalSegment(outgoing).End()
+	}, r)
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+
+	db, external, server := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "GET /users/{id}", server.Name())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, codes.Error, server.Status().Code)
+
+	assert.Equal(t, "select users", db.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), db.Parent().SpanID())
+
+	assert.Equal(t, "GET gws.local", external.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), external.Parent().SpanID())
+	assert.Equal(t,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-"+external.SpanContext().SpanID().String()+"-01",
+		outgoing.Header.Get("traceparent"))
+}
+
+func TestOpenTelemetryTransactionNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		setName  string
+		expected string
+		err      error
+	}{
+		{"route pattern", "", "GET /users/{id}", nil},
+		{"explicit name", "users.show", "users.show", nil},
+		{"noticed error", "", "GET /users/{id}", errors.New("boom")},
+	}
+
+	for _, test := range tests {
+		recorder, tracer := newRecorder()
+
+		serve(tracer, "/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+			txn := FromContext(r.Context())
+			if test.setName != "" {
+				txn.SetName(test.setName)
+			}
+			if test.err != nil {
+				txn.NoticeError(test.err)
+			}
+		}, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+		spans := recorder.Ended()
+		if assert.Len(t, spans, 1, test.name) {
+			assert.Equal(t, test.expected, spans[0].Name(), test.name)
+			if test.err != nil {
+				assert.Equal(t, codes.Error, spans[0].Status().Code, test.name)
+				assert.Len(t, spans[0].Events(), 1, test.name)
+			} else {
+				assert.Equal(t, codes.Unset, spans[0].Status().Code, test.name)
+			}
+		}
+	}
+}
+
+func TestMulti(t *testing.T) {
+	first, firstTracer := newRecorder()
+	second, secondTracer := newRecorder()
+
+	serve(Multi(firstTracer, secondTracer), "/", func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).StartSegment("work").End()
+	}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Len(t, first.Ended(), 2)
+	assert.Len(t, second.Ended(), 2)
+}
+
+func TestRouteName(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		method   string
+		path     string
+		expected string
+	}{
+		{"/users/{id}", http.MethodGet, "/users/42", "GET /users/{id}"},
+		{"/users/{id}", http.MethodGet, "/users/43", "GET /users/{id}"},
+		{"/classes/*", http.MethodGet, "/classes/1/children", "GET /classes/*"},
+		{"/users/{id}", http.MethodGet, "/unknown", "GET unmatched"},
+	}
+
+	for _, test := range tests {
+		var name string
+		router := chi.NewRouter()
+		router.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r)
+				name = RouteName(r)
+			})
+		})
+		router.Get(test.pattern, func(w http.ResponseWriter, r *http.Request) {})
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, test.path, nil))
+
+		assert.Equal(t, test.expected, name, test.path)
+	}
+
+	assert.Equal(t, "POST unmatched", RouteName(httptest.NewRequest(http.MethodPost, "/", nil)))
+}
+
+func TestOpenTelemetryBackgroundTransaction(t *testing.T) {
+	recorder, tracer := newRecorder()
+
+	txn := tracer.StartBackgroundTransaction("log_error")
+	txn.NoticeError(newrelic.Error{Message: "job failed", Attributes: map[string]interface{}{"job": "sync"}})
+	txn.End()
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "log_error", spans[0].Name())
+		assert.Equal(t, trace.SpanKindInternal, spans[0].SpanKind())
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		if assert.Len(t, spans[0].Events(), 1) {
+			var job string
+			for _, kv := range spans[0].Events()[0].Attributes {
+				if kv.Key == "job" {
+					job = kv.Value.AsString()
+				}
+			}
+			assert.Equal(t, "sync", job)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package apm
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	newrelic "github.com/newrelic/go-agent"
+)
+
+// NewRelic returns a Tracer reporting to a New Relic application
+// Transactions are named after the route pattern once the request is served, see RouteName.
+// The New Relic transaction is also stored under utils.ContextKey("txn"), for code predating this package.
+func NewRelic(app newrelic.Application) Tracer {
+	return newRelicTracer{app: app}
+}
+
+type newRelicTracer struct {
+	app newrelic.Application
+}
+
+func (t newRelicTracer) StartTransaction(w http.ResponseWriter, r *http.Request) (Transaction, context.Context) {
+	txn := &newRelicTransaction{txn: t.app.StartTransaction(r.URL.Path, w, r), request: r}
+	ctx := context.WithValue(r.Context(), utils.ContextKey("txn"), txn.txn)
+
+	return txn, NewContext(ctx, txn)
+}
+
+func (t newRelicTracer) StartBackgroundTransaction(name string) Transaction {
+	return &newRelicTransaction{txn: t.app.StartTransaction(name, nil, nil), named: true}
+}
+
+func (t newRelicTracer) Shutdown(timeout time.Duration) {
+	t.app.Shutdown(timeout)
+}
+
+// newRelicTransaction adapts a newrelic.Transaction
+type newRelicTransaction struct {
+	txn     newrelic.Transaction
+	request *http.Request
+	named   bool
+}
+
+// NewRelicTransaction returns the New Relic transaction underlying a Transaction, if any
+func NewRelicTransaction(txn Transaction) (newrelic.Transaction, bool) {
+	if nr, ok := txn.(*newRelicTransaction); ok {
+		return nr.txn, true
+	}
+	if m, ok := txn.(multiTransaction); ok {
+		for _, t := range m {
+			if nr, ok := NewRelicTransaction(t); ok {
+				return nr, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (t *newRelicTransaction) SetName(name string) {
+	t.named = true
+	t.txn.SetName(name)
+}
+
+func (t *newRelicTransaction) AddAttribute(key string, value interface{}) {
+	t.txn.AddAttribute(key, value)
+}
+
+func (t *newRelicTransaction) NoticeError(err error) {
+	t.txn.NoticeError(err)
+}
+
+func (t *newRelicTransaction) StartSegment(name string) Segment {
+	return newRelicSegment{newrelic.StartSegment(t.txn, name).End}
+}
+
+func (t *newRelicTransaction) StartDatastoreSegment(s DatastoreSegment) Segment {
+	segment := &newrelic.DatastoreSegment{
+		StartTime:          newrelic.StartSegmentNow(t.txn),
+		Product:            newrelic.DatastoreProduct(s.Product),
+		Collection:         s.Collection,
+		Operation:          s.Operation,
+		ParameterizedQuery: s.Query,
+		Host:               s.Host,
+		PortPathOrID:       s.Port,
+		DatabaseName:       s.Database,
+	}
+	return newRelicSegment{segment.End}
+}
+
+func (t *newRelicTransaction) StartExternalSegment(r *http.Request) Segment {
+	return newRelicSegment{newrelic.StartExternalSegment(t.txn, r).End}
+}
+
+// End names the transaction after the route pattern matched by chi, unless it was named explicitly
+func (t *newRelicTransaction) End() {
+	if !t.named && t.request != nil {
+		t.txn.SetName(RouteName(t.request))
+	}
+	t.txn.End()
+}
+
+type newRelicSegment struct {
+	end func() error
+}
+
+func (s newRelicSegment) End() {
+	s.end()
+}
@@ -0,0 +1,175 @@
+package apm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	newrelic "github.com/newrelic/go-agent"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans created by this package
+const instrumentationName = "bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+
+// propagator reads and writes the W3C trace context and baggage headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// OpenTelemetry returns a Tracer creating spans with an OpenTelemetry tracer provider, see NewOTLPTracerProvider
+// Incoming traceparent headers are honored, and outgoing requests started with StartExternalSegment carry one.
+// The server span is named after the chi route pattern once the request is served, e.g. "GET /users/{id}".
+func OpenTelemetry(provider trace.TracerProvider) Tracer {
+	return &otelTracer{provider: provider, tracer: provider.Tracer(instrumentationName)}
+}
+
+type otelTracer struct {
+	provider trace.TracerProvider
+	tracer   trace.Tracer
+}
+
+func (t *otelTracer) StartTransaction(w http.ResponseWriter, r *http.Request) (Transaction, context.Context) {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	ctx, span := t.tracer.Start(ctx, r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.RequestURI()),
+			attribute.String("http.user_agent", r.UserAgent()),
+			attribute.String("net.host.name", r.Host),
+		),
+	)
+
+	txn := &otelTransaction{tracer: t.tracer, span: span, ctx: ctx, request: r}
+	return txn, NewContext(ctx, txn)
+}
+
+func (t *otelTracer) StartBackgroundTransaction(name string) Transaction {
+	ctx, span := t.tracer.Start(context.Background(), name, trace.WithSpanKind(trace.SpanKindInternal))
+	return &otelTransaction{tracer: t.tracer, span: span, ctx: ctx, named: true}
+}
+
+func (t *otelTracer) Shutdown(timeout time.Duration) {
+	if sp, ok := t.provider.(interface{ Shutdown(context.Context) error }); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		sp.Shutdown(ctx)
+	}
+}
+
+// otelTransaction is the server span of a request
+type otelTransaction struct {
+	tracer  trace.Tracer
+	span    trace.Span
+	ctx     context.Context
+	request *http.Request
+	named   bool
+}
+
+func (t *otelTransaction) SetName(name string) {
+	t.named = true
+	t.span.SetName(name)
+}
+
+func (t *otelTransaction) AddAttribute(key string, value interface{}) {
+	t.span.SetAttributes(attributeOf(key, value))
+
+	if key == AttributeStatusCode {
+		if code, ok := value.(int); ok && code >= http.StatusInternalServerError {
+			t.span.SetStatus(codes.Error, http.StatusText(code))
+		}
+	}
+}
+
+func (t *otelTransaction) NoticeError(err error) {
+	var opts []trace.EventOption
+	if attributer, ok := err.(newrelic.ErrorAttributer); ok {
+		for key, value := range attributer.ErrorAttributes() {
+			opts = append(opts, trace.WithAttributes(attributeOf(key, value)))
+		}
+	}
+
+	t.span.RecordError(err, opts...)
+	t.span.SetStatus(codes.Error, err.Error())
+}
+
+func (t *otelTransaction) StartSegment(name string) Segment {
+	_, span := t.tracer.Start(t.ctx, name)
+	return otelSegment{span}
+}
+
+func (t *otelTransaction) StartDatastoreSegment(s DatastoreSegment) Segment {
+	_, span := t.tracer.Start(t.ctx, s.Operation+" "+s.Collection,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", s.Product),
+			attribute.String("db.name", s.Database),
+			attribute.String("db.operation", s.Operation),
+			attribute.String("db.sql.table", s.Collection),
+			attribute.String("db.statement", s.Query),
+			attribute.String("net.peer.name", s.Host),
+			attribute.String("net.peer.port", s.Port),
+		),
+	)
+	return otelSegment{span}
+}
+
+func (t *otelTransaction) StartExternalSegment(r *http.Request) Segment {
+	ctx, span := t.tracer.Start(t.ctx, r.Method+" "+r.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.url", r.URL.String()),
+		),
+	)
+	propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	return otelSegment{span}
+}
+
+// End names the span after the route pattern matched by chi, unless it was named explicitly, see RouteName
+func (t *otelTransaction) End() {
+	if t.request != nil {
+		if rctx, ok := t.request.Context().Value(chi.RouteCtxKey).(*chi.Context); ok && rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				t.span.SetAttributes(attribute.String("http.route", pattern))
+			}
+		}
+		if !t.named {
+			t.span.SetName(RouteName(t.request))
+		}
+	}
+	t.span.End()
+}
+
+type otelSegment struct {
+	span trace.Span
+}
+
+func (s otelSegment) End() {
+	s.span.End()
+}
+
+// attributeOf converts an attribute value to its OpenTelemetry type
+func attributeOf(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case fmt.Stringer:
+		return attribute.Stringer(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
@@ -0,0 +1,73 @@
+package apm
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// DefaultOTLPEndpoint is the address of a local OpenTelemetry collector
+const DefaultOTLPEndpoint = "localhost:4318"
+
+// OTLPConfig configures the export of spans over OTLP/HTTP
+type OTLPConfig struct {
+	// Endpoint is the host:port of the collector, DefaultOTLPEndpoint when empty
+	Endpoint string
+	// Insecure sends spans over plain HTTP
+	Insecure bool
+	// Headers are sent with every export, e.g. API keys
+	Headers map[string]string
+	// ServiceName and Environment describe the service in every span
+	ServiceName string
+	Environment string
+	// SampleRatio is the fraction of new traces recorded, all of them when zero
+	// Traces started upstream follow the sampling decision of their parent.
+	SampleRatio float64
+}
+
+// NewOTLPTracerProvider returns a tracer provider exporting spans in batches to an OTLP collector
+// The provider should be shut down when the service stops, which Tracer.Shutdown does.
+//
+// Return Values:
+//     1st: The tracer provider, to be passed to OpenTelemetry
+//     2nd: An error when the exporter cannot be created
+func NewOTLPTracerProvider(ctx context.Context, config OTLPConfig) (*sdktrace.TracerProvider, error) {
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if config.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(config.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(config.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := config.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	res := resource.NewSchemaless(
+		semconv.ServiceName(config.ServiceName),
+		semconv.DeploymentEnvironment(config.Environment),
+	)
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	), nil
+}
@@ -0,0 +1,144 @@
+// Package apperr provides an application error carrying everything needed to answer a request:
+// the HTTP status, a message safe to show to clients, a machine readable code, validation failures,
+// and the internal cause which is only ever logged.
+package apperr
+
+import (
+	"errors"
+	"net/http"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"github.com/rs/zerolog"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// Error is an application error that maps to a StandardResponse
+type Error struct {
+	// Status is the HTTP status of the response
+	Status int
+	// Message is shown to clients, it must not leak internals
+	Message string
+	// Code is an optional machine readable error code, e.g. "user_not_found"
+	Code string
+	// Cause is the internal error, logged but never shown to clients
+	Cause error
+	// Validations are the validation failures shown to clients
+	Validations []response.ValidationFailure
+	// Level is the level the error is logged at
+	Level zerolog.Level
+}
+
+// New creates an application error
+// Server errors (status 500 and above) are logged at error level, others at warn level.
+func New(status int, message string) *Error {
+	level := zerolog.WarnLevel
+	if status >= http.StatusInternalServerError {
+		level = zerolog.ErrorLevel
+	}
+
+	return &Error{Status: status, Message: message, Level: level}
+}
+
+// Wrap creates an application error caused by err
+func Wrap(err error, status int, message string) *Error {
+	return New(status, message).WithCause(err)
+}
+
+// NotFound creates a 404 application error
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, message)
+}
+
+// Conflict creates a 409 application error
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, message)
+}
+
+// Forbidden creates a 403 application error
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, message)
+}
+
+// Unauthorized creates a 401 application error
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, message)
+}
+
+// BadRequest creates a 400 application error
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, message)
+}
+
+// Validation creates a 422 application error carrying validation failures
+func Validation(message string, failures ...response.ValidationFailure) *Error {
+	e := New(http.StatusUnprocessableEntity, message)
+	e.Validations = failures
+	return e
+}
+
+// ValidatorV9 creates a 422 application error from validator v9 errors
+func ValidatorV9(message string, errs validator.ValidationErrors) *Error {
+	failures := make([]response.ValidationFailure, len(errs))
+	for i, e := range errs {
+		failures[i] = e
+	}
+	return Validation(message, failures...)
+}
+
+// Internal creates a 500 application error caused by err, with a generic message
+func Internal(err error) *Error {
+	return Wrap(err, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+// WithCode sets the machine readable error code
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+// WithCause sets the internal cause
+func (e *Error) WithCause(err error) *Error {
+	e.Cause = err
+	return e
+}
+
+// WithLevel sets the level the error is logged at
+func (e *Error) WithLevel(level zerolog.Level) *Error {
+	e.Level = level
+	return e
+}
+
+// Error implements error, including the internal cause
+func (e *Error) Error() string {
+	if e.Cause == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Cause.Error()
+}
+
+// Unwrap returns the internal cause, for errors.Is and errors.As
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Response builds the StandardResponse of the error
+func (e *Error) Response() *response.StandardResponse {
+	res := response.New()
+	res.StatusCode = e.Status
+	res.Message = e.Message
+	res.Code = e.Code
+	if len(e.Validations) > 0 {
+		res.SetValidationFailures(e.Validations)
+	}
+	return res
+}
+
+// From returns the application error of err
+// Errors that do not wrap an application error become internal errors, so that their text is never shown.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Internal(err)
+}
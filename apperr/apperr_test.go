@@ -0,0 +1,74 @@
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+type failure struct{}
+
+func (failure) Field() string      { return "email" }
+func (failure) Tag() string        { return "required" }
+func (failure) Value() interface{} { return "" }
+func (failure) Param() string      { return "" }
+
+func TestHelpers(t *testing.T) {
+	tests := []struct {
+		err    *Error
+		status int
+		level  zerolog.Level
+	}{
+		{NotFound("User not found"), http.StatusNotFound, zerolog.WarnLevel},
+		{Conflict("Email already registered"), http.StatusConflict, zerolog.WarnLevel},
+		{Forbidden("Not allowed"), http.StatusForbidden, zerolog.WarnLevel},
+		{Unauthorized("Sign in required"), http.StatusUnauthorized, zerolog.WarnLevel},
+		{BadRequest("Malformed body"), http.StatusBadRequest, zerolog.WarnLevel},
+		{Validation("Invalid user", failure{}), http.StatusUnprocessableEntity, zerolog.WarnLevel},
+		{Internal(errors.New("connection refused")), http.StatusInternalServerError, zerolog.ErrorLevel},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.status, test.err.Status, test.err.Message)
+		assert.Equal(t, test.level, test.err.Level, test.err.Message)
+	}
+}
+
+func TestErrorWrapping(t *testing.T) {
+	cause := errors.New("no rows in result set")
+	err := NotFound("User not found").WithCode("user_not_found").WithCause(cause)
+
+	assert.Equal(t, "User not found: no rows in result set", err.Error())
+	assert.True(t, errors.Is(err, cause))
+
+	wrapped := fmt.Errorf("loading user: %w", err)
+	assert.True(t, From(wrapped) == err, "application error found through wrapping")
+
+	internal := From(cause)
+	assert.Equal(t, http.StatusInternalServerError, internal.Status)
+	assert.Equal(t, "Internal Server Error", internal.Message)
+	assert.Equal(t, cause, internal.Cause)
+}
+
+func TestResponse(t *testing.T) {
+	err := Validation("Invalid user", failure{}).WithCode("invalid_user").WithCause(errors.New("secret detail"))
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, response.Send(w, err.Response()))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.NotContains(t, w.Body.String(), "secret detail")
+
+	var body response.StandardBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "Invalid user", body.Message)
+	assert.Equal(t, "invalid_user", body.Code)
+	assert.Equal(t, "client error", body.Status)
+	assert.Equal(t, "email", (*body.Validations)[0].Field)
+}
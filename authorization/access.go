@@ -1,6 +1,7 @@
 package authorization
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,8 @@ import (
 	"net/url"
 	"path"
 
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
 	"github.com/rs/zerolog"
 )
 
@@ -45,18 +48,32 @@ type TeamMemberType struct {
 
 // LoadAccess loads access data from authorization service for given userID
 func LoadAccess(authorizationServiceURL, userID, token string, logger *zerolog.Logger) (*Access, error) {
+	return LoadAccessContext(context.Background(), authorizationServiceURL, userID, token, logger)
+}
+
+// LoadAccessContext loads access data from authorization service for given userID, bound to ctx
+// The call is traced as an external segment of the APM transaction of ctx, and logged with
+// the request logger of ctx when there is one.
+func LoadAccessContext(ctx context.Context, authorizationServiceURL, userID, token string, logger *zerolog.Logger) (*Access, error) {
+	if reqLogger, ok := utils.LoggerFromContext(ctx); ok {
+		logger = reqLogger
+	}
+
 	serverURL, err := url.Parse(authorizationServiceURL)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse authorization service url: %v", err)
 	}
 	serverURL.Path = path.Join(serverURL.Path, "access", userID)
 
-	req, err := http.NewRequest("GET", serverURL.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", serverURL.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create a request to authorization service: %v", err)
 	}
 	req.Header.Set("iam", token)
 
+	segment := apm.FromContext(ctx).StartExternalSegment(req)
+	defer segment.End()
+
 	resp, err := (&http.Client{}).Do(req)
 	if err != nil {
 		return nil, err
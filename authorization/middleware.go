@@ -147,7 +147,7 @@ func MakeAuthValidator(authorizationServiceURL string, logger *zerolog.Logger) C
 		if token, ok = t.(string); !ok {
 			return r, ErrNoTokenFound
 		}
-		access, err := LoadAccess(authorizationServiceURL, subscription, token, logger)
+		access, err := LoadAccessContext(r.Context(), authorizationServiceURL, subscription, token, logger)
 		if err != nil {
 			return r, err
 		}
@@ -2,13 +2,19 @@ package gws
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 )
 
@@ -18,6 +24,15 @@ type Proxy interface {
 	Get(signedIam *string, endpoint string, queryString *url.Values) (int, []byte, error)
 }
 
+// ContextProxy is a Proxy whose requests are bound to a context, which cancels them and
+// traces them as external calls of the APM transaction it carries
+// Proxies returned by NewProxy implement it.
+type ContextProxy interface {
+	Proxy
+	PostContext(ctx context.Context, signedIam *string, endpoint string, payload []byte) (int, []byte, error)
+	GetContext(ctx context.Context, signedIam *string, endpoint string, queryString *url.Values) (int, []byte, error)
+}
+
 type proxy struct {
 	config gwsConfig
 	logger *zerolog.Logger
@@ -35,6 +50,17 @@ type StandardResponse struct {
 	Message string `json:"message"`
 }
 
+// requestDuration records the duration of GWS requests, see Collector
+var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "gws_request_duration_seconds",
+	Help: "Duration of GWS requests by method and status code, 0 when no response was received.",
+}, []string{"method", "code"})
+
+// Collector returns the Prometheus collector of GWS request durations
+func Collector() prometheus.Collector {
+	return requestDuration
+}
+
 var (
 	ErrProxyRequestFailed  = errors.New("invalid status from gws")
 	ErrProxyMisconfigured  = errors.New("proxy misconfigured")
@@ -66,36 +92,56 @@ func NewProxy(baseURI string, authToken string, sharedSecret []byte, logger *zer
 
 // Post issues an HTTP Post to GWS
 func (p *proxy) Post(signedIam *string, endpoint string, payload []byte) (status int, jsn []byte, err error) {
-	return p.makeRequest(signedIam, http.MethodPost, endpoint, bytes.NewReader(payload), &url.Values{})
+	return p.PostContext(context.Background(), signedIam, endpoint, payload)
 }
 
 // Get issues an HTTP Get to GWS
 func (p *proxy) Get(signedIam *string, endpoint string, queryString *url.Values) (status int, jsn []byte, err error) {
-	return p.makeRequest(signedIam, http.MethodGet, endpoint, nil, queryString)
+	return p.GetContext(context.Background(), signedIam, endpoint, queryString)
 }
 
-func (p *proxy) makeRequest(signedIam *string, method string, endpoint string, body io.Reader, queryString *url.Values) (status int, jsn []byte, err error) {
+// PostContext issues an HTTP Post to GWS, bound to ctx
+func (p *proxy) PostContext(ctx context.Context, signedIam *string, endpoint string, payload []byte) (status int, jsn []byte, err error) {
+	return p.makeRequest(ctx, signedIam, http.MethodPost, endpoint, bytes.NewReader(payload), &url.Values{})
+}
+
+// GetContext issues an HTTP Get to GWS, bound to ctx
+func (p *proxy) GetContext(ctx context.Context, signedIam *string, endpoint string, queryString *url.Values) (status int, jsn []byte, err error) {
+	return p.makeRequest(ctx, signedIam, http.MethodGet, endpoint, nil, queryString)
+}
+
+func (p *proxy) makeRequest(ctx context.Context, signedIam *string, method string, endpoint string, body io.Reader, queryString *url.Values) (status int, jsn []byte, err error) {
 
 	status = http.StatusInternalServerError
 
+	// requests of a handler log with its request logger, see utils.LoggerFromContext
+	logger := p.logger
+	if reqLogger, ok := utils.LoggerFromContext(ctx); ok {
+		logger = reqLogger
+	}
+
 	if p.config.baseURI == nil {
-		p.logger.Error().Err(err).Msg("p baseURI is nil")
+		logger.Error().Err(err).Msg("p baseURI is nil")
 		return status, jsn, ErrProxyMisconfigured
 	}
 
 	uri := buildURI(p.config.baseURI, endpoint, queryString)
 
-	request, err := http.NewRequest(method, uri, body)
+	request, err := http.NewRequestWithContext(ctx, method, uri, body)
 	if err != nil {
-		p.logger.Error().Err(err).Str("uri", uri).Msg("could not create request")
+		logger.Error().Err(err).Str("uri", uri).Msg("could not create request")
 		return status, jsn, ErrProxyInvalidRequest
 	}
 
 	addHeaders(request, signedIam, p.config.authToken)
 
-	status, resBody, err := send(request, p.logger)
+	segment := apm.FromContext(ctx).StartExternalSegment(request)
+	start := time.Now()
+	status, resBody, err := send(request, logger)
+	segment.End()
+	requestDuration.WithLabelValues(method, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
 	if err != nil {
-		p.logger.Error().Err(err).Str("uri", uri).Msg("could not send request")
+		logger.Error().Err(err).Str("uri", uri).Msg("could not send request")
 		return status, jsn, err
 	}
 
@@ -1,6 +1,13 @@
 package health
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrNotReady is reported by IsHealthy once the collection is marked as not ready, e.g. while shutting down
+var ErrNotReady = errors.New("service is not ready")
 
 type HealthCheckFunc func() (bool, error)
 
@@ -11,6 +18,7 @@ type HealthCheck struct {
 
 type HealthCheckCollection struct {
 	healthChecks []*HealthCheck
+	notReady     int32
 }
 
 func NewHealthCheckCollection() *HealthCheckCollection {
@@ -27,7 +35,26 @@ func (hcc *HealthCheckCollection) AddHealthCheck(name string, check HealthCheckF
 	hcc.healthChecks = append(hcc.healthChecks, &hc)
 }
 
+// SetReady marks the service as ready to serve traffic or not
+// A collection is ready until marked otherwise, e.g. by the server package when draining before shutdown.
+func (hcc *HealthCheckCollection) SetReady(ready bool) {
+	var notReady int32
+	if !ready {
+		notReady = 1
+	}
+	atomic.StoreInt32(&hcc.notReady, notReady)
+}
+
+// IsReady reports whether the service is ready to serve traffic
+func (hcc *HealthCheckCollection) IsReady() bool {
+	return atomic.LoadInt32(&hcc.notReady) == 0
+}
+
 func (hcc *HealthCheckCollection) IsHealthy() (bool, error) {
+	if !hcc.IsReady() {
+		return false, ErrNotReady
+	}
+
 	for _, hc := range hcc.healthChecks {
 		ok, err := hc.Check()
 		if !ok {
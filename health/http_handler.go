@@ -6,6 +6,7 @@ import (
 
 // GetServiceHealth ranges over a set of health checks, validates that each is ok
 // and responds accordingly
+// A service marked as not ready with SetReady responds with a 503.
 // This is a very minor variant of what we have in the go-svc-bootstrap, modified
 // only to support the mux style definitions that we're using now.
 func GetServiceHealth(healthChecks *HealthCheckCollection, serviceName string) http.HandlerFunc {
@@ -15,9 +16,13 @@ func GetServiceHealth(healthChecks *HealthCheckCollection, serviceName string) h
 		res.Header().Set("Server", serviceName)
 
 		healthy, err := healthChecks.IsHealthy()
-		if healthy {
+		switch {
+		case healthy:
 			res.WriteHeader(http.StatusOK)
-		} else {
+		case err == ErrNotReady:
+			// tells load balancers to stop routing traffic here
+			http.Error(res, err.Error(), http.StatusServiceUnavailable)
+		default:
 			http.Error(res, err.Error(), http.StatusInternalServerError)
 		}
 
@@ -0,0 +1,43 @@
+package health
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	checkDesc = prometheus.NewDesc("health_check_status",
+		"Result of each health check, 1 when healthy.", []string{"check"}, nil)
+	readyDesc = prometheus.NewDesc("health_ready",
+		"Whether the service is ready to serve traffic, 0 while shutting down.", nil, nil)
+)
+
+// Collector returns a Prometheus collector running the health checks on each scrape
+func (hcc *HealthCheckCollection) Collector() prometheus.Collector {
+	return healthCollector{hcc: hcc}
+}
+
+type healthCollector struct {
+	hcc *HealthCheckCollection
+}
+
+// Describe implements prometheus.Collector
+func (c healthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- checkDesc
+	ch <- readyDesc
+}
+
+// Collect implements prometheus.Collector
+func (c healthCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, hc := range c.hcc.healthChecks {
+		ok, _ := hc.Check()
+		ch <- prometheus.MustNewConstMetric(checkDesc, prometheus.GaugeValue, boolValue(ok), hc.Name)
+	}
+	ch <- prometheus.MustNewConstMetric(readyDesc, prometheus.GaugeValue, boolValue(c.hcc.IsReady()))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,260 @@
+// Package i18n translates response messages and validation failures to the request locale.
+//
+// Messages are organized in catalogs, one per locale, named after the bundle name of the locale
+// (see middleware.LocaleTag.BundleName), e.g. "en_US.json", "es_US.toml" or "en_US_NC.json".
+// A catalog maps message ids to either a message, or its plural forms:
+//
+//     {
+//         "user_not_found": "User {id} not found",
+//         "items_found": {"one": "{count} item found", "other": "{count} items found"},
+//         "validation.required": "{field} is required"
+//     }
+//
+// Messages are looked up from the most to the least specific locale, e.g. en_US_NC, en_US, en,
+// then the default locale of the bundle.
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+)
+
+var (
+	// ErrUnsupportedCatalog is returned when a catalog file is neither JSON nor TOML
+	ErrUnsupportedCatalog = errors.New("unsupported catalog format")
+	// ErrInvalidMessage is returned when a catalog entry is neither a message nor plural forms
+	ErrInvalidMessage = errors.New("invalid catalog message")
+)
+
+// Message is a translated message and its plural forms
+// Forms are keyed by CLDR plural category: zero, one, two, few, many and other.
+// A message without plural forms only has Other set.
+type Message struct {
+	Zero  string
+	One   string
+	Two   string
+	Few   string
+	Many  string
+	Other string
+}
+
+// form returns the text of a plural form, falling back to other
+func (m Message) form(f plural.Form) string {
+	var text string
+	switch f {
+	case plural.Zero:
+		text = m.Zero
+	case plural.One:
+		text = m.One
+	case plural.Two:
+		text = m.Two
+	case plural.Few:
+		text = m.Few
+	case plural.Many:
+		text = m.Many
+	}
+	if text == "" {
+		return m.Other
+	}
+	return text
+}
+
+// catalog holds the messages of one locale
+type catalog struct {
+	tag      language.Tag
+	messages map[string]Message
+}
+
+// Bundle holds the catalogs of all locales
+type Bundle struct {
+	defaultLocale string
+
+	mu       sync.RWMutex
+	catalogs map[string]*catalog
+}
+
+// NewBundle creates an empty bundle
+// defaultLocale is the bundle name of the locale used when no catalog of the request locale has a message, e.g. "en_US".
+func NewBundle(defaultLocale string) *Bundle {
+	return &Bundle{defaultLocale: defaultLocale, catalogs: map[string]*catalog{}}
+}
+
+// AddMessages adds messages to the catalog of a locale, replacing messages with the same id
+func (b *Bundle) AddMessages(locale string, messages map[string]Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.catalogs[locale]
+	if !ok {
+		c = &catalog{tag: localeTag(locale), messages: map[string]Message{}}
+		b.catalogs[locale] = c
+	}
+	for id, message := range messages {
+		c.messages[id] = message
+	}
+}
+
+// Load adds the catalogs found in a directory of fsys
+// Each .json or .toml file is the catalog of the locale named after it, other files are ignored.
+func (b *Bundle) Load(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".json" && ext != ".toml") {
+			continue
+		}
+
+		if err := b.LoadFile(fsys, path.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadFile adds the catalog of a single .json or .toml file, named after its locale
+func (b *Bundle) LoadFile(fsys fs.FS, name string) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+
+	messages, err := ParseCatalog(path.Ext(name), data)
+	if err != nil {
+		return fmt.Errorf("unable to load catalog %s: %w", name, err)
+	}
+
+	b.AddMessages(strings.TrimSuffix(path.Base(name), path.Ext(name)), messages)
+
+	return nil
+}
+
+// ParseCatalog parses the messages of a catalog
+//
+// Return Values:
+//     1st: The messages by id
+//     2nd: ErrUnsupportedCatalog for an extension other than .json or .toml, or an error representing failure to parse
+func ParseCatalog(ext string, data []byte) (map[string]Message, error) {
+	raw := map[string]interface{}{}
+
+	switch ext {
+	case ".json":
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(data), &raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCatalog, ext)
+	}
+
+	messages := make(map[string]Message, len(raw))
+	for id, value := range raw {
+		message, err := parseMessage(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, id)
+		}
+		messages[id] = message
+	}
+
+	return messages, nil
+}
+
+// parseMessage converts a decoded catalog entry into a Message
+func parseMessage(value interface{}) (Message, error) {
+	switch v := value.(type) {
+	case string:
+		return Message{Other: v}, nil
+	case map[string]interface{}:
+		var message Message
+		for category, text := range v {
+			s, ok := text.(string)
+			if !ok {
+				return message, ErrInvalidMessage
+			}
+			switch category {
+			case "zero":
+				message.Zero = s
+			case "one":
+				message.One = s
+			case "two":
+				message.Two = s
+			case "few":
+				message.Few = s
+			case "many":
+				message.Many = s
+			case "other":
+				message.Other = s
+			default:
+				return message, ErrInvalidMessage
+			}
+		}
+		return message, nil
+	}
+
+	return Message{}, ErrInvalidMessage
+}
+
+// lookup finds a message in the catalogs of the given locales, in order
+func (b *Bundle) lookup(locales []string, id string) (Message, language.Tag, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, locale := range locales {
+		if c, ok := b.catalogs[locale]; ok {
+			if message, ok := c.messages[id]; ok {
+				return message, c.tag, true
+			}
+		}
+	}
+
+	return Message{}, language.Und, false
+}
+
+// fallbacks returns the locales searched for a locale, from the most to the least specific
+// e.g. en_US_NC, en_US, en, then the default locale.
+func (b *Bundle) fallbacks(locale string) []string {
+	var locales []string
+
+	parts := strings.Split(locale, "_")
+	for i := len(parts); i > 0; i-- {
+		if name := strings.Join(parts[:i], "_"); name != "" {
+			locales = append(locales, name)
+		}
+	}
+	if locale != b.defaultLocale {
+		locales = append(locales, b.defaultLocale)
+	}
+
+	return locales
+}
+
+// localeTag returns the language tag of a bundle name, ignoring the variant
+// which is not a BCP 47 variant, e.g. en-US for en_US_NC.
+func localeTag(locale string) language.Tag {
+	parts := strings.Split(locale, "_")
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+
+	tag, err := language.Parse(strings.Join(parts, "-"))
+	if err != nil {
+		return language.Und
+	}
+	return tag
+}
@@ -0,0 +1,157 @@
+package i18n
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	mw "bitbucket.org/teachingstrategies/go-svc-bootstrap/middlewares"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+var catalogs = fstest.MapFS{
+	"locales/en_US.json": {Data: []byte(`{
+		"user_not_found": "User {id} not found",
+		"items_found": {"one": "{count} item found", "other": "{count} items found"},
+		"invalid_user": "Invalid user",
+		"validation.required": "{field} is required",
+		"validation.email.email": "{value} is not an email address"
+	}`)},
+	"locales/es_US.toml": {Data: []byte(`
+user_not_found = "Usuario {id} no encontrado"
+invalid_user = "Usuario inválido"
+"validation.required" = "{field} es obligatorio"
+
+[items_found]
+one = "{count} elemento encontrado"
+other = "{count} elementos encontrados"
+`)},
+	"locales/es_US_NC.json": {Data: []byte(`{"invalid_user": "Usuario no válido"}`)},
+	"locales/pl_PL.json":    {Data: []byte(`{"items_found": {"one": "{count} element", "few": "{count} elementy", "many": "{count} elementów"}}`)},
+	"locales/README.md":     {Data: []byte(`not a catalog`)},
+}
+
+func loadBundle(t *testing.T) *Bundle {
+	b := NewBundle("en_US")
+	assert.NoError(t, b.Load(catalogs, "locales"))
+	return b
+}
+
+type failure struct {
+	field, tag string
+	value      interface{}
+}
+
+func (f failure) Field() string      { return f.field }
+func (f failure) Tag() string        { return f.tag }
+func (f failure) Value() interface{} { return f.value }
+func (f failure) Param() string      { return "" }
+
+func TestMessage(t *testing.T) {
+	b := loadBundle(t)
+
+	tests := []struct {
+		locale   string
+		id       string
+		expected string
+	}{
+		{"en_US", "user_not_found", "User 7 not found"},
+		{"es_US", "user_not_found", "Usuario 7 no encontrado"},
+		{"es_US_NC", "invalid_user", "Usuario no válido"},
+		{"es_US_NC", "user_not_found", "Usuario 7 no encontrado"},
+		{"fr_FR", "user_not_found", "User 7 not found"},
+		{"es_US", "unknown_message", "unknown_message"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, b.Localizer(test.locale).Message(test.id, Args{"id": 7}), test.locale+" "+test.id)
+	}
+}
+
+func TestPlural(t *testing.T) {
+	b := loadBundle(t)
+
+	tests := []struct {
+		locale   string
+		count    int
+		expected string
+	}{
+		{"en_US", 1, "1 item found"},
+		{"en_US", 0, "0 items found"},
+		{"en_US", 5, "5 items found"},
+		{"es_US", 1, "1 elemento encontrado"},
+		{"es_US", 2, "2 elementos encontrados"},
+		{"pl_PL", 1, "1 element"},
+		{"pl_PL", 3, "3 elementy"},
+		{"pl_PL", 5, "5 elementów"},
+		{"pl_PL", 22, "22 elementy"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, b.Localizer(test.locale).Plural("items_found", test.count, nil), test.locale)
+	}
+}
+
+func TestParseCatalogErrors(t *testing.T) {
+	_, err := ParseCatalog(".yaml", []byte(""))
+	assert.True(t, errors.Is(err, ErrUnsupportedCatalog))
+
+	_, err = ParseCatalog(".json", []byte(`{"a": {"several": "x"}}`))
+	assert.True(t, errors.Is(err, ErrInvalidMessage))
+
+	_, err = ParseCatalog(".json", []byte(`{"a": 1}`))
+	assert.True(t, errors.Is(err, ErrInvalidMessage))
+}
+
+func TestLocalizedResponse(t *testing.T) {
+	b := loadBundle(t)
+
+	r := chi.NewRouter()
+	r.Route("/api", mw.AddLocale(func(r chi.Router) {
+		r.With(b.Middleware).Get("/users", func(w http.ResponseWriter, r *http.Request) {
+			sr := Localize(r, response.New())
+			sr.StatusCode = http.StatusUnprocessableEntity
+			sr.Message = "invalid_user"
+			sr.AddValidationFailure(failure{field: "name", tag: "required"})
+			sr.AddValidationFailure(failure{field: "email", tag: "email", value: "nope"})
+			sr.AddValidationFailure(failure{field: "age", tag: "min"})
+			response.Send(w, sr)
+		})
+	}))
+
+	tests := []struct {
+		path     string
+		message  string
+		messages []string
+	}{
+		{"/api/users", "Invalid user", []string{"name is required", "nope is not an email address", ""}},
+		{"/api/es-us/users", "Usuario inválido", []string{"name es obligatorio", "nope is not an email address", ""}},
+		{"/api/es-us-nc/users", "Usuario no válido", []string{"name es obligatorio", "nope is not an email address", ""}},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+
+		var body response.StandardBody
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), test.path)
+		assert.Equal(t, test.message, body.Message, test.path)
+		for i, message := range test.messages {
+			assert.Equal(t, message, (*body.Validations)[i].Message, test.path)
+		}
+	}
+}
+
+func TestForRequestWithoutLocale(t *testing.T) {
+	b := loadBundle(t)
+	r := httptest.NewRequest("GET", "/", nil)
+
+	assert.Equal(t, "Invalid user", b.ForRequest(r).Translate("invalid_user"))
+	assert.Nil(t, FromContext(context.Background()))
+}
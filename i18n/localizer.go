@@ -0,0 +1,142 @@
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	mw "bitbucket.org/teachingstrategies/go-svc-bootstrap/middlewares"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"golang.org/x/text/feature/plural"
+)
+
+// ValidationPrefix prefixes the ids of validation failure messages, e.g. "validation.required",
+// or "validation.email.required" for a message specific to the email field
+const ValidationPrefix = "validation."
+
+// localizerKey is the context key of the request Localizer
+const localizerKey = contextKey("localizer")
+
+type contextKey string
+
+func (c contextKey) String() string {
+	return "i18n context key " + string(c)
+}
+
+// Args are the values of the {name} placeholders of a message
+type Args map[string]interface{}
+
+// Localizer translates messages to a locale
+// It implements response.Translator.
+type Localizer struct {
+	bundle  *Bundle
+	locales []string
+}
+
+// Localizer returns a Localizer for a locale bundle name, e.g. "es_US"
+func (b *Bundle) Localizer(locale string) *Localizer {
+	return &Localizer{bundle: b, locales: b.fallbacks(locale)}
+}
+
+// ForRequest returns a Localizer for the locale of a request routed through middleware.AddLocale,
+// or for the default locale otherwise
+func (b *Bundle) ForRequest(r *http.Request) *Localizer {
+	if tag := mw.LocaleTagFromContext(r.Context()); tag != nil {
+		return b.Localizer(tag.BundleName())
+	}
+	return b.Localizer(b.defaultLocale)
+}
+
+// Middleware adds the Localizer of the request locale to the request context, see FromContext
+// It must run after the locale middleware, i.e. within the routes given to middleware.AddLocale.
+func (b *Bundle) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), localizerKey, b.ForRequest(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// FromContext returns the Localizer added by Bundle.Middleware, or nil
+func FromContext(ctx context.Context) *Localizer {
+	l, _ := ctx.Value(localizerKey).(*Localizer)
+	return l
+}
+
+// Localize sets the Localizer of the request context as the translator of a response
+// The response is left untouched when the context has no Localizer.
+func Localize(r *http.Request, sr *response.StandardResponse) *response.StandardResponse {
+	if l := FromContext(r.Context()); l != nil {
+		sr.SetTranslator(l)
+	}
+	return sr
+}
+
+// Message returns the translation of a message id with its placeholders replaced by args
+// The id is returned when no catalog has the message.
+func (l *Localizer) Message(id string, args Args) string {
+	message, _, ok := l.bundle.lookup(l.locales, id)
+	if !ok {
+		return id
+	}
+	return interpolate(message.Other, args)
+}
+
+// Plural returns the plural form of a message id matching count, with its placeholders replaced
+// by args and {count}
+// The form is chosen with the CLDR plural rules of the locale of the catalog the message is found in.
+func (l *Localizer) Plural(id string, count int, args Args) string {
+	message, tag, ok := l.bundle.lookup(l.locales, id)
+	if !ok {
+		return id
+	}
+
+	n := count
+	if n < 0 {
+		n = -n
+	}
+	form := plural.Cardinal.MatchPlural(tag, n, 0, 0, 0, 0)
+
+	values := Args{"count": count}
+	for k, v := range args {
+		values[k] = v
+	}
+
+	return interpolate(message.form(form), values)
+}
+
+// Translate implements response.Translator, treating the message as a message id
+func (l *Localizer) Translate(message string) string {
+	return l.Message(message, nil)
+}
+
+// TranslateValidation implements response.Translator
+// The message is looked up as "validation.<field>.<tag>", then "validation.<tag>", with the
+// {field}, {param} and {value} placeholders.
+func (l *Localizer) TranslateValidation(f response.ValidationFailure) string {
+	args := Args{"field": f.Field(), "param": f.Param(), "value": f.Value()}
+
+	for _, id := range []string{ValidationPrefix + f.Field() + "." + f.Tag(), ValidationPrefix + f.Tag()} {
+		if message, _, ok := l.bundle.lookup(l.locales, id); ok {
+			return interpolate(message.Other, args)
+		}
+	}
+
+	return ""
+}
+
+// interpolate replaces the {name} placeholders of a message
+func interpolate(text string, args Args) string {
+	if len(args) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+
+	pairs := make([]string, 0, len(args)*2)
+	for k, v := range args {
+		pairs = append(pairs, "{"+k+"}", fmt.Sprint(v))
+	}
+
+	return strings.NewReplacer(pairs...).Replace(text)
+}
@@ -11,9 +11,19 @@ type TimeValue interface {
 }
 
 // TimerValuer provides a value for validation
+// Types implementing TimeValue with a pointer receiver, such as Date and DateTime, are supported too.
 func TimerValuer(field reflect.Value) interface{} {
 	if t, ok := field.Interface().(TimeValue); ok {
 		return t.Time()
 	}
+	if field.Kind() == reflect.Ptr {
+		return nil
+	}
+
+	ptr := reflect.New(field.Type())
+	ptr.Elem().Set(field)
+	if t, ok := ptr.Interface().(TimeValue); ok {
+		return t.Time()
+	}
 	return nil
 }
@@ -30,6 +30,13 @@ func TestTimerValuer(t *testing.T) {
 			}(),
 			want: now,
 		},
+		{
+			name: "Converts Date reflect.Value to time.Time",
+			reflectValue: func() reflect.Value {
+				return reflect.ValueOf(Date(now))
+			}(),
+			want: now,
+		},
 		{
 			name: "Does not convert string reflect.Value to time.Time",
 			reflectValue: func() reflect.Value {
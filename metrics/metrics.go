@@ -0,0 +1,158 @@
+// Package metrics records HTTP RED metrics (rate, errors, duration) and serves them, with the metrics
+// collected by other packages, in the Prometheus and OpenMetrics text formats.
+//
+// Other bootstrap packages expose collectors to register, e.g.
+//
+//     m := metrics.New(metrics.Config{Namespace: "planning"})
+//     m.MustRegister(db.Collector(), gws.Collector(), healthChecks.Collector())
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	middleware "bitbucket.org/teachingstrategies/go-svc-bootstrap/middlewares"
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultPath is the path router.MountMetrics serves metrics on
+const DefaultPath = "/metrics"
+
+// UnmatchedRoute is the route label of requests that matched no route, keeping the label cardinality bounded
+const UnmatchedRoute = "unmatched"
+
+// Config configures Metrics
+type Config struct {
+	// Namespace prefixes the metric names, e.g. "planning" for planning_http_requests_total
+	Namespace string
+	// Buckets are the request duration histogram buckets, in seconds (default prometheus.DefBuckets)
+	Buckets []float64
+	// SkipRuntimeCollectors leaves out the Go runtime and process collectors
+	SkipRuntimeCollectors bool
+}
+
+// Metrics holds a registry and the HTTP metrics recorded by its middleware
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+}
+
+// New creates Metrics with its own registry
+func New(config Config) *Metrics {
+	buckets := config.Buckets
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: config.Namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: config.Namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of HTTP requests by route pattern, method and status code.",
+			Buckets:   buckets,
+		}, []string{"route", "method", "code"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: config.Namespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "Number of HTTP requests being served by method.",
+		}, []string{"method"}),
+	}
+
+	m.registry.MustRegister(m.requests, m.duration, m.inFlight)
+	if !config.SkipRuntimeCollectors {
+		m.registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	return m
+}
+
+// Register registers collectors with the registry
+func (m *Metrics) Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := m.registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MustRegister registers collectors with the registry, panicking on failure
+func (m *Metrics) MustRegister(cs ...prometheus.Collector) {
+	m.registry.MustRegister(cs...)
+}
+
+// Registry exposes the registry, e.g. to gather metrics in tests
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the registered metrics, in the OpenMetrics text format when the scraper accepts it
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// Middleware records the count, duration and in-flight number of requests
+// Requests are labeled by chi route pattern rather than path, e.g. "/users/{id}", so it must wrap the chi router.
+// Panics are recorded as 500s, unless the response was started, and propagated to the recoverer.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		inFlight := m.inFlight.WithLabelValues(r.Method)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		ww := middleware.WrapResponseWriter(w)
+
+		defer func() {
+			status := ww.Status()
+
+			// panics are answered with a 500 by an outer recoverer, unless the response was started
+			rvr := recover()
+			if rvr != nil && !ww.Written() {
+				status = http.StatusInternalServerError
+			}
+
+			labels := prometheus.Labels{"route": routePattern(r), "method": r.Method, "code": strconv.Itoa(status)}
+			m.requests.With(labels).Inc()
+			m.duration.With(labels).Observe(time.Since(start).Seconds())
+
+			if rvr != nil {
+				panic(rvr)
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// routePattern returns the chi route pattern matched by a request
+func routePattern(r *http.Request) string {
+	rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+	if !ok {
+		return UnmatchedRoute
+	}
+	if pattern := rctx.RoutePattern(); pattern != "" {
+		return pattern
+	}
+	return UnmatchedRoute
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	m := New(Config{Namespace: "test", SkipRuntimeCollectors: true})
+
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	r.Route("/admin", func(r chi.Router) {
+		r.Post("/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	r.Handle(DefaultPath, m.Handler())
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/0", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/admin/jobs/3", nil))
+	assert.PanicsWithValue(t, "boom", func() {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	}, "panics are propagated")
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", DefaultPath, nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	r.ServeHTTP(w, req)
+	out := w.Body.String()
+
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/openmetrics-text"))
+	assert.Contains(t, out, `test_http_requests_total{code="200",method="GET",route="/users/{id}"} 2`)
+	assert.Contains(t, out, `test_http_requests_total{code="404",method="GET",route="/users/{id}"} 1`)
+	assert.Contains(t, out, `test_http_requests_total{code="404",method="GET",route="unmatched"} 1`)
+	assert.Contains(t, out, `test_http_requests_total{code="200",method="POST",route="/admin/jobs/{id}"} 1`)
+	assert.Contains(t, out, `test_http_requests_total{code="500",method="GET",route="/panic"} 1`)
+	assert.Contains(t, out, `test_http_request_duration_seconds_count{code="200",method="GET",route="/users/{id}"} 2`)
+	assert.Contains(t, out, `test_http_requests_in_flight{method="GET"} 1`, "the scrape itself")
+	assert.True(t, strings.HasSuffix(out, "# EOF\n"))
+}
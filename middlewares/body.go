@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// LimitBody limits the size of request bodies to maxBytes
+// Reading past the limit fails and closes the connection, see http.MaxBytesReader.
+func LimitBody(maxBytes int64) func(next http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,326 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultCompressionThreshold is the response size, in bytes, above which responses are compressed by router.New
+const DefaultCompressionThreshold = 1024
+
+// ErrHijackAfterWrite is returned when hijacking the connection of a compressed response once it started
+var ErrHijackAfterWrite = errors.New("middleware: cannot hijack the connection once the response started")
+
+// compressionEncodings are the supported content codings, in order of preference at equal quality
+var compressionEncodings = []string{"br", "gzip", "deflate"}
+
+// AddCompression compresses responses of at least minSize bytes with the best content coding
+// accepted by the client (br, gzip or deflate)
+// Responses are buffered up to minSize to decide, except when the handler flushes, which
+// starts compression right away so that streamed responses are compressed as they go.
+// Responses that are already encoded, have no body, or are not text-like are left untouched.
+func AddCompression(minSize int) func(next http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+
+			// protocol upgrades (websockets) need the raw connection
+			if encoding == "" || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize, head: r.Method == http.MethodHead}
+			defer cw.close()
+
+			next.ServeHTTP(cw.wrap(), r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// negotiateEncoding selects the preferred supported content coding of an Accept-Encoding header
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+		} else {
+			qualities[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range compressionEncodings {
+		q, ok := qualities[coding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+// isCompressible reports whether a content type benefits from compression
+func isCompressible(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-ndjson", "application/msgpack", "image/svg+xml":
+		return true
+	}
+
+	return false
+}
+
+// compressWriter buffers the start of a response to decide whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+
+	encoding string
+	minSize  int
+	head     bool
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	compressor  io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = code
+
+	// bodiless responses are not worth waiting for
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.compressor != nil {
+			return cw.compressor.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// wrap returns cw implementing http.Flusher, http.Hijacker and http.Pusher only when the
+// underlying writer does, like WrapResponseWriter
+func (cw *compressWriter) wrap() http.ResponseWriter {
+	_, fl := cw.ResponseWriter.(http.Flusher)
+	_, hj := cw.ResponseWriter.(http.Hijacker)
+	_, ps := cw.ResponseWriter.(http.Pusher)
+
+	switch {
+	case fl && hj && ps:
+		return struct {
+			*compressWriter
+			compressFlusher
+			compressHijacker
+			compressPusher
+		}{cw, compressFlusher{cw}, compressHijacker{cw}, compressPusher{cw}}
+	case fl && hj:
+		return struct {
+			*compressWriter
+			compressFlusher
+			compressHijacker
+		}{cw, compressFlusher{cw}, compressHijacker{cw}}
+	case fl && ps:
+		return struct {
+			*compressWriter
+			compressFlusher
+			compressPusher
+		}{cw, compressFlusher{cw}, compressPusher{cw}}
+	case hj && ps:
+		return struct {
+			*compressWriter
+			compressHijacker
+			compressPusher
+		}{cw, compressHijacker{cw}, compressPusher{cw}}
+	case fl:
+		return struct {
+			*compressWriter
+			compressFlusher
+		}{cw, compressFlusher{cw}}
+	case hj:
+		return struct {
+			*compressWriter
+			compressHijacker
+		}{cw, compressHijacker{cw}}
+	case ps:
+		return struct {
+			*compressWriter
+			compressPusher
+		}{cw, compressPusher{cw}}
+	}
+
+	return cw
+}
+
+type compressFlusher struct {
+	cw *compressWriter
+}
+
+// Flush starts compressing right away and sends what was written so far
+func (f compressFlusher) Flush() {
+	cw := f.cw
+	if !cw.decided {
+		if !cw.wroteHeader {
+			cw.WriteHeader(http.StatusOK)
+		}
+		cw.decide(true)
+	}
+
+	if flusher, ok := cw.compressor.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	cw.ResponseWriter.(http.Flusher).Flush()
+}
+
+type compressHijacker struct {
+	cw *compressWriter
+}
+
+// Hijack exposes the underlying connection, for handlers upgrading without an Upgrade request header
+// The connection cannot be taken over once the response started, as its header may already be sent
+// or its body buffered.
+func (h compressHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h.cw.wroteHeader || h.cw.decided {
+		return nil, nil, ErrHijackAfterWrite
+	}
+	return h.cw.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+type compressPusher struct {
+	cw *compressWriter
+}
+
+func (p compressPusher) Push(target string, opts *http.PushOptions) error {
+	return p.cw.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// decide writes the header, compressed or not, followed by the buffered body
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	eligible := isCompressible(h.Get("Content-Type")) &&
+		h.Get("Content-Encoding") == "" &&
+		cw.status >= http.StatusOK && cw.status != http.StatusNoContent && cw.status != http.StatusNotModified
+
+	if eligible || cw.status == http.StatusNotModified {
+		// caches must keep the representations of each content coding apart
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	if compress && eligible && !cw.head {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// the compressed bytes differ from the ones a strong ETag was computed from
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.compressor = newCompressor(cw.encoding, cw.ResponseWriter)
+	}
+
+	if cw.wroteHeader {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+	if cw.compressor != nil {
+		_, err := cw.compressor.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// close completes the response once the handler returns
+func (cw *compressWriter) close() {
+	if !cw.decided && cw.wroteHeader {
+		// the whole response is smaller than the threshold
+		cw.decide(false)
+	}
+	if cw.compressor != nil {
+		cw.compressor.Close()
+	}
+}
+
+func newCompressor(encoding string, w io.Writer) io.WriteCloser {
+	switch encoding {
+	case "br":
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	case "deflate":
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	}
+	return gzip.NewWriter(w)
+}
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, *", "gzip"},
+		{"*;q=0.1, deflate", "deflate"},
+		{"GZIP", "gzip"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, negotiateEncoding(test.header), test.header)
+	}
+}
+
+func serveCompressed(body string, contentType string, acceptEncoding string, etag string) *httptest.ResponseRecorder {
+	handler := AddCompression(16)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestAddCompression(t *testing.T) {
+	body := strings.Repeat(`{"message":"ok"}`, 10)
+
+	// gzip
+	w := serveCompressed(body, "application/json", "gzip", `"abc"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"), "strong ETag is weakened")
+	gr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadAll(gr)
+	assert.Equal(t, body, string(b))
+
+	// brotli
+	w = serveCompressed(body, "application/json", "gzip, br", "")
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	b, _ = ioutil.ReadAll(brotli.NewReader(w.Body))
+	assert.Equal(t, body, string(b))
+
+	// below the threshold
+	w = serveCompressed(`{"a":1}`, "application/json", "gzip", `"abc"`)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+	assert.Equal(t, `{"a":1}`, w.Body.String())
+
+	// not compressible
+	w = serveCompressed(body, "image/png", "gzip", "")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, w.Body.String())
+
+	// not accepted
+	w = serveCompressed(body, "application/json", "identity", "")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, w.Body.String())
+}
+
+func TestAddCompressionNotModified(t *testing.T) {
+	handler := AddCompression(16)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, 0, w.Body.Len())
+}
+
+func TestAddCompressionFlush(t *testing.T) {
+	handler := AddCompression(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("1\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("2\n"))
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, r)
+
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	gr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadAll(gr)
+	assert.Equal(t, "1\n2\n", string(b))
+}
+
+func TestAddCompressionInterfaces(t *testing.T) {
+	tests := []struct {
+		name                      string
+		w                         http.ResponseWriter
+		flusher, hijacker, pusher bool
+	}{
+		{"basic", basicWriter{httptest.NewRecorder()}, false, false, false},
+		{"flusher", httptest.NewRecorder(), true, false, false},
+		{"hijacker and pusher", &hijackPushWriter{ResponseWriter: httptest.NewRecorder()}, false, true, true},
+		{"all", &fancyWriter{ResponseRecorder: httptest.NewRecorder()}, true, true, true},
+	}
+
+	for _, test := range tests {
+		var fl, hj, ps bool
+		handler := AddCompression(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, fl = w.(http.Flusher)
+			_, hj = w.(http.Hijacker)
+			_, ps = w.(http.Pusher)
+			if ps {
+				assert.NoError(t, w.(http.Pusher).Push("/app.js", nil), test.name)
+			}
+		}))
+
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(test.w, r)
+
+		assert.Equal(t, test.flusher, fl, test.name)
+		assert.Equal(t, test.hijacker, hj, test.name)
+		assert.Equal(t, test.pusher, ps, test.name)
+	}
+
+	pusher := &hijackPushWriter{ResponseWriter: httptest.NewRecorder()}
+	r := httptest.NewRequest("GET", "/test", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	AddCompression(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Pusher).Push("/app.js", nil)
+	})).ServeHTTP(pusher, r)
+	assert.Equal(t, "/app.js", pusher.pushed, "pushes reach the underlying writer")
+}
+
+func TestAddCompressionHijack(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter)
+		err     error
+	}{
+		{"before writing", func(w http.ResponseWriter) {}, nil},
+		{"header written", func(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) }, ErrHijackAfterWrite},
+		{"body buffered", func(w http.ResponseWriter) { w.Write([]byte("partial")) }, ErrHijackAfterWrite},
+	}
+
+	for _, test := range tests {
+		var err error
+		handler := AddCompression(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			test.handler(w)
+			_, _, err = w.(http.Hijacker).Hijack()
+		}))
+
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(&hijackPushWriter{ResponseWriter: httptest.NewRecorder()}, r)
+
+		assert.Equal(t, test.err, err, test.name)
+	}
+}
@@ -0,0 +1,326 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// DefaultCorsMethods are the methods allowed by Cors when none are configured
+	DefaultCorsMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch}
+	// DefaultCorsHeaders are the request headers allowed by Cors when none are configured
+	DefaultCorsHeaders = []string{"Authorization", "Origin", "Content-Type"}
+)
+
+// CorsOptions configures Cors
+type CorsOptions struct {
+	// AllowedOrigins are the origins allowed to make requests: exact origins ("https://app.example.com"),
+	// subdomain wildcards ("https://*.example.com") or "*" for any origin
+	AllowedOrigins []string
+	// AllowedOriginPatterns are regular expressions matched against the whole origin
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowedMethods are the methods allowed in preflight requests (default DefaultCorsMethods)
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in preflight requests (default DefaultCorsHeaders),
+	// "*" allows any requested header
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers readable by the client
+	ExposedHeaders []string
+	// AllowCredentials allows requests with cookies and authorization headers
+	AllowCredentials bool
+	// MaxAge is how long preflight results may be cached by the client, zero omits Access-Control-Max-Age
+	MaxAge time.Duration
+	// AllowNoOrigin lets requests without an Origin header through, e.g. same-origin or server to server requests,
+	// instead of answering them with a 400
+	AllowNoOrigin bool
+	// Routes overrides the methods and headers of specific routes, keyed by chi route pattern, e.g. "/users/{id}"
+	Routes map[string]CorsRoute
+	// Logger logs rejected origins when set
+	Logger *zerolog.Logger
+}
+
+// CorsRoute configures the methods and headers of a single route
+// Empty fields fall back to the CorsOptions ones.
+type CorsRoute struct {
+	Methods        []string
+	Headers        []string
+	ExposedHeaders []string
+}
+
+// Cors handles cross-origin requests
+// Preflight requests (OPTIONS with Access-Control-Request-Method) are answered with a 204 and are not passed on.
+// Actual requests from an allowed origin get the CORS headers and the origin added to the request context,
+// see OriginFromContext. Requests from other origins are answered with a 400, as are requests without an
+// Origin header unless AllowNoOrigin is set.
+func Cors(opts CorsOptions) func(next http.Handler) http.Handler {
+	c := newCors(opts)
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+
+			if origin == "" {
+				if c.allowNoOrigin {
+					next.ServeHTTP(w, r)
+					return
+				}
+				c.deny(w, r, origin, "Origin is required")
+				return
+			}
+
+			if !c.originAllowed(origin) {
+				c.deny(w, r, origin, "Origin is not permitted")
+				return
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				c.preflight(w, r, origin)
+				return
+			}
+
+			c.setOrigin(w, origin)
+			exposed := c.exposedHeaders
+			if route := c.route(r, r.Method); route != nil && len(route.ExposedHeaders) > 0 {
+				exposed = strings.Join(route.ExposedHeaders, ", ")
+			}
+			if exposed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposed)
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), originTagKey, origin)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// cors is the compiled form of CorsOptions
+type cors struct {
+	anyOrigin        bool
+	origins          map[string]struct{}
+	wildcards        [][2]string
+	patterns         []*regexp.Regexp
+	methods          []string
+	headers          []string
+	anyHeader        bool
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+	allowNoOrigin    bool
+	routes           map[string]CorsRoute
+	logger           *zerolog.Logger
+}
+
+func newCors(opts CorsOptions) *cors {
+	c := &cors{
+		origins:          map[string]struct{}{},
+		methods:          opts.AllowedMethods,
+		headers:          opts.AllowedHeaders,
+		exposedHeaders:   strings.Join(opts.ExposedHeaders, ", "),
+		allowCredentials: opts.AllowCredentials,
+		allowNoOrigin:    opts.AllowNoOrigin,
+		routes:           opts.Routes,
+		logger:           opts.Logger,
+	}
+
+	for _, origin := range opts.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.Contains(origin, "*"):
+			parts := strings.SplitN(origin, "*", 2)
+			c.wildcards = append(c.wildcards, [2]string{parts[0], parts[1]})
+		default:
+			c.origins[origin] = struct{}{}
+		}
+	}
+
+	if len(c.methods) == 0 {
+		c.methods = DefaultCorsMethods
+	}
+	if len(c.headers) == 0 {
+		c.headers = DefaultCorsHeaders
+	}
+	for _, h := range c.headers {
+		if h == "*" {
+			c.anyHeader = true
+		}
+	}
+
+	// patterns must match the whole origin, not its leftmost match
+	for _, p := range opts.AllowedOriginPatterns {
+		c.patterns = append(c.patterns, regexp.MustCompile(`^(?:`+p.String()+`)$`))
+	}
+
+	if opts.MaxAge > 0 {
+		c.maxAge = strconv.FormatInt(int64(opts.MaxAge/time.Second), 10)
+	}
+
+	return c
+}
+
+// originAllowed matches an origin against the allowed origins
+func (c *cors) originAllowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if _, ok := c.origins[origin]; ok {
+		return true
+	}
+	for _, w := range c.wildcards {
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
+			return true
+		}
+	}
+	for _, p := range c.patterns {
+		if p.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setOrigin sets the allowed origin and credentials headers
+// A wildcard origin is only sent without credentials, as browsers reject it otherwise.
+func (c *cors) setOrigin(w http.ResponseWriter, origin string) {
+	if c.anyOrigin && !c.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers a preflight request
+// A method or header that is not allowed gets no CORS headers, which fails the request in the browser.
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	methods, headers := c.methods, c.headers
+	anyHeader := c.anyHeader
+	if route := c.route(r, method); route != nil {
+		if len(route.Methods) > 0 {
+			methods = route.Methods
+		}
+		if len(route.Headers) > 0 {
+			headers = route.Headers
+			anyHeader = false
+		}
+	}
+
+	requested := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+	if !contains(methods, method) || (!anyHeader && !containsAll(headers, requested)) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	c.setOrigin(w, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if anyHeader {
+		if len(requested) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+	} else {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if c.maxAge != "" {
+		h.Set("Access-Control-Max-Age", c.maxAge)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeMethods are the methods tried to find the route pattern of a path
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// route returns the configuration of the route matching a request, or nil
+// The route is matched with the given method first, then with any method, so that a preflight
+// for a method the route does not handle still gets the route configuration.
+func (c *cors) route(r *http.Request, method string) *CorsRoute {
+	if len(c.routes) == 0 {
+		return nil
+	}
+
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return nil
+	}
+
+	path := rctx.RoutePath
+	if path == "" {
+		path = r.URL.Path
+	}
+
+	for _, m := range append([]string{method}, routeMethods...) {
+		tctx := chi.NewRouteContext()
+		if !rctx.Routes.Match(tctx, m, path) {
+			continue
+		}
+		if route, ok := c.routes[tctx.RoutePattern()]; ok {
+			return &route
+		}
+		return nil
+	}
+
+	return nil
+}
+
+func (c *cors) deny(w http.ResponseWriter, r *http.Request, origin string, message string) {
+	if c.logger != nil {
+		c.logger.Warn().Str("originIn", origin).Str("path", r.URL.Path).Msg(message)
+	}
+
+	res := response.New()
+	res.StatusCode = http.StatusBadRequest
+	res.Message = message
+	response.Send(w, res)
+}
+
+// parseHeaderList splits a comma separated list of header names into canonical header names
+func parseHeaderList(list string) []string {
+	var headers []string
+	for _, h := range strings.Split(list, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	return headers
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(values []string, wanted []string) bool {
+	for _, w := range wanted {
+		if !contains(values, w) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+func corsRouter(opts CorsOptions) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(Cors(opts))
+	router.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(OriginFromContext(r.Context())))
+	})
+	router.Put("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	return router
+}
+
+func TestCorsOrigins(t *testing.T) {
+	router := corsRouter(CorsOptions{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.teachingstrategies.com"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`http://localhost:\d+`), regexp.MustCompile(`https://a\.dev|https://a\.dev\.example\.com`)},
+		ExposedHeaders:        []string{"X-Request-Id"},
+		AllowCredentials:      true,
+	})
+
+	tests := []struct {
+		origin     string
+		wantStatus int
+	}{
+		{"https://app.example.com", http.StatusOK},
+		{"HTTPS://APP.EXAMPLE.COM", http.StatusOK},
+		{"https://planning.teachingstrategies.com", http.StatusOK},
+		{"https://teachingstrategies.com", http.StatusBadRequest},
+		{"http://planning.teachingstrategies.com", http.StatusBadRequest},
+		{"http://localhost:3000", http.StatusOK},
+		{"http://localhost:3000.evil.com", http.StatusBadRequest},
+		{"https://a.dev.example.com", http.StatusOK},
+		{"https://a.dev.evil.com", http.StatusBadRequest},
+		{"https://evil.com", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/users", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, tt.wantStatus, w.Code, tt.origin)
+		assert.Equal(t, "Origin", w.Header().Get("Vary"), tt.origin)
+		if tt.wantStatus == http.StatusOK {
+			assert.Equal(t, tt.origin, w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+			assert.Equal(t, "X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+			assert.Equal(t, tt.origin, w.Body.String(), "origin in context")
+		} else {
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+		}
+	}
+}
+
+func TestCorsNoOrigin(t *testing.T) {
+	router := corsRouter(CorsOptions{AllowedOrigins: []string{"*"}, AllowNoOrigin: true})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/users", nil)
+	r.Header.Set("Origin", "https://any.com")
+	router.ServeHTTP(w, r)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"), "wildcard without credentials")
+}
+
+func TestCorsPreflight(t *testing.T) {
+	router := corsRouter(CorsOptions{
+		AllowedOrigins: []string{"https://app.example.com"},
+		MaxAge:         10 * time.Minute,
+		Routes: map[string]CorsRoute{
+			"/users/{id}": {Methods: []string{"PUT"}, Headers: []string{"Authorization", "Content-Type", "If-Match"}},
+		},
+	})
+
+	tests := []struct {
+		name        string
+		path        string
+		method      string
+		headers     string
+		wantMethods string
+		wantHeaders string
+	}{
+		{"default", "/users", "POST", "content-type", "GET, POST, PUT, DELETE, PATCH", "Authorization, Origin, Content-Type"},
+		{"route", "/users/12", "PUT", "if-match", "PUT", "Authorization, Content-Type, If-Match"},
+		{"route method", "/users/12", "DELETE", "", "", ""},
+		{"header", "/users", "GET", "x-custom", "", ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("OPTIONS", tt.path, nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", tt.method)
+		r.Header.Set("Access-Control-Request-Headers", tt.headers)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNoContent, w.Code, tt.name)
+		assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, w.Header()["Vary"], tt.name)
+		assert.Equal(t, tt.wantMethods, w.Header().Get("Access-Control-Allow-Methods"), tt.name)
+		assert.Equal(t, tt.wantHeaders, w.Header().Get("Access-Control-Allow-Headers"), tt.name)
+		if tt.wantMethods != "" {
+			assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"), tt.name)
+			assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"), tt.name)
+		} else {
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), tt.name)
+		}
+	}
+}
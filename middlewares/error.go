@@ -4,8 +4,10 @@ import (
 	"net/http"
 	"runtime/debug"
 
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apperr"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
 	"github.com/go-chi/chi/middleware"
-	newrelic "github.com/newrelic/go-agent"
 	"github.com/rs/zerolog"
 )
 
@@ -15,19 +17,44 @@ type ObservedHandler func(w http.ResponseWriter, r *http.Request) error
 
 // ErrorObserver leverages the error response of an observed handler to
 // provide visibility
+// Returned errors are rendered as a StandardResponse from their apperr.Error: status, public message,
+// code and validation failures. Any other error is answered with a generic 500, its text is only logged.
+// When the handler already started the response, the error is logged and noticed but not rendered,
+// and an error status it sent is the status the error is logged with, so that e.g. a 4xx sent before
+// returning an unclassified error is not reported as a server error.
 func ErrorObserver(h ObservedHandler, logger *zerolog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		err := h(w, r)
+		ww := WrapResponseWriter(w)
+
+		err := h(ww, r)
 
 		if err != nil {
 
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			appErr := apperr.From(err)
+
+			if ww.Written() && ww.Status() >= http.StatusBadRequest {
+				// the error status sent, e.g. a 406 from response.SendFor, tells what the error was
+				appErr = apperr.Wrap(err, ww.Status(), http.StatusText(ww.Status()))
+			}
 
-			logger.Error().Timestamp().Bytes("debug_stack", debug.Stack()).Str("rid", middleware.GetReqID(r.Context())).Msg(err.Error())
+			if !ww.Written() {
+				if sendErr := response.SendFor(ww, r, appErr.Response()); sendErr != nil {
+					logger.Error().Timestamp().Str("rid", middleware.GetReqID(r.Context())).Err(sendErr).Msg("unable to send error response")
+				}
+			}
+
+			event := logger.WithLevel(appErr.Level).Timestamp().Str("rid", middleware.GetReqID(r.Context())).Int("status", appErr.Status)
+			if appErr.Code != "" {
+				event = event.Str("code", appErr.Code)
+			}
+			if appErr.Level >= zerolog.ErrorLevel {
+				event = event.Bytes("debug_stack", debug.Stack())
+			}
+			event.Msg(err.Error())
 
-			if txn, ok := w.(newrelic.Transaction); ok {
-				txn.NoticeError(err)
+			if appErr.Status >= http.StatusInternalServerError {
+				apm.FromContext(r.Context()).NoticeError(err)
 			}
 
 		}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apperr"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorObserver(t *testing.T) {
+	tests := []struct {
+		err      error
+		status   int
+		body     string
+		logLevel string
+	}{
+		{
+			apperr.NotFound("User not found").WithCode("user_not_found").WithCause(errors.New("no rows")),
+			http.StatusNotFound,
+			`{"message":"User not found","status":"client error","code":"user_not_found"}`,
+			`"level":"warn"`,
+		},
+		{
+			errors.New("dial tcp 10.0.0.1:5432: connection refused"),
+			http.StatusInternalServerError,
+			`{"message":"Internal Server Error","status":"server error"}`,
+			`"level":"error"`,
+		},
+	}
+
+	for _, test := range tests {
+		var logs bytes.Buffer
+		logger := zerolog.New(&logs)
+
+		handler := ErrorObserver(func(w http.ResponseWriter, r *http.Request) error {
+			return test.err
+		}, &logger)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/users/1", nil))
+
+		assert.Equal(t, test.status, w.Code)
+		assert.Equal(t, test.body, w.Body.String())
+		assert.Contains(t, logs.String(), test.logLevel)
+		assert.Contains(t, logs.String(), test.err.Error(), "cause is logged")
+	}
+}
+
+func TestErrorObserverResponseStarted(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	tracer := &recordingTracer{}
+
+	handler := AddTracing(tracer)(ErrorObserver(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"items":[`))
+		return errors.New("stream interrupted")
+	}, &logger))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"items":[`, w.Body.String(), "nothing is rendered after the response started")
+	assert.Contains(t, logs.String(), "stream interrupted", "still logged")
+	assert.NotContains(t, logs.String(), "unable to send error response")
+	if assert.Len(t, tracer.txn.errors, 1, "still noticed") {
+		assert.EqualError(t, tracer.txn.errors[0], "stream interrupted")
+	}
+}
+
+func TestErrorObserverSendFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		status int
+	}{
+		{"not acceptable", "/users/1", "image/png", http.StatusNotAcceptable},
+		{"field not allowed", "/users/1?fields=password", "", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		var logs bytes.Buffer
+		logger := zerolog.New(&logs)
+		tracer := &recordingTracer{}
+
+		handler := AddTracing(tracer)(ErrorObserver(func(w http.ResponseWriter, r *http.Request) error {
+			sr := response.New()
+			sr.StatusCode = http.StatusOK
+			sr.Data = map[string]int{"id": 1}
+			sr.AllowFields("id")
+			return response.SendFor(w, r, sr)
+		}, &logger))
+
+		r := httptest.NewRequest("GET", test.target, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, test.status, w.Code, test.name)
+		assert.Empty(t, logs.String(), test.name+" is not an error")
+		assert.Empty(t, tracer.txn.errors, test.name)
+	}
+}
+
+func TestErrorObserverWrittenStatus(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	tracer := &recordingTracer{}
+
+	handler := AddTracing(tracer)(ErrorObserver(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusConflict)
+		return errors.New("version mismatch")
+	}, &logger))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/users/1", nil))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, logs.String(), `"level":"warn"`)
+	assert.Contains(t, logs.String(), `"status":409`)
+	assert.NotContains(t, logs.String(), "debug_stack")
+	assert.Empty(t, tracer.txn.errors, "client errors are not noticed")
+}
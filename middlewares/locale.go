@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
 	"golang.org/x/text/language"
 
 	"github.com/go-chi/chi"
@@ -53,9 +54,13 @@ func AddLocale(routes func(r chi.Router)) func(r chi.Router) {
 	}
 }
 
-// LocaleTagFromContext returns the locale tag from the given context.
+// LocaleTagFromContext returns the locale tag from the given context,
+// or nil when the request was not routed through AddLocale.
 func LocaleTagFromContext(ctx context.Context) *LocaleTag {
-	tag := ctx.Value(localeTagKey).(LocaleTag)
+	tag, ok := ctx.Value(localeTagKey).(LocaleTag)
+	if !ok {
+		return nil
+	}
 
 	return &tag
 }
@@ -82,6 +87,7 @@ func localeMiddleware(next http.Handler) http.Handler {
 		}
 
 		ctx := context.WithValue(r.Context(), localeTagKey, localeTag)
+		apm.FromContext(ctx).AddAttribute(apm.AttributeLocale, localeTag.Original())
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -2,21 +2,127 @@ package middleware
 
 import (
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
 	tsjwt "bitbucket.org/teachingstrategies/go-svc-bootstrap/tsjwt"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/rs/zerolog"
 )
 
+// LogFormat is the format of access logs
+type LogFormat int
+
+const (
+	// LogFormatJSON logs access as structured fields
+	LogFormatJSON LogFormat = iota
+	// LogFormatCombined logs access as a line of the Apache combined log format, for local development
+	LogFormatCombined
+)
+
+// Access log fields, see LoggingOptions.Fields
+const (
+	LogFieldRemoteIP  = "remote_ip"
+	LogFieldHost      = "host"
+	LogFieldURL       = "url"
+	LogFieldQuery     = "query"
+	LogFieldProto     = "proto"
+	LogFieldMethod    = "method"
+	LogFieldUserAgent = "user_agent"
+	LogFieldDuration  = "dur_ms"
+	LogFieldTTFB      = "ttfb_ms"
+	LogFieldStatus    = "status"
+	LogFieldUserID    = "uid"
+	LogFieldVisitorID = "vid"
+	LogFieldBytesIn   = "bytes_in"
+	LogFieldBytesOut  = "bytes_out"
+	LogFieldRoute     = "route"
+	LogFieldReferer   = "referer"
+	LogFieldLocale    = "locale"
+	LogFieldOrigin    = "origin"
+)
+
+var (
+	// DefaultLogFields are the access log fields of AddLogging
+	DefaultLogFields = []string{
+		LogFieldRemoteIP, LogFieldHost, LogFieldURL, LogFieldQuery, LogFieldProto, LogFieldMethod,
+		LogFieldUserAgent, LogFieldDuration, LogFieldTTFB, LogFieldStatus, LogFieldUserID, LogFieldVisitorID,
+		LogFieldBytesIn, LogFieldBytesOut, LogFieldRoute, LogFieldReferer, LogFieldLocale, LogFieldOrigin,
+	}
+
+	// DefaultRedactedParams are the query parameters carrying secrets, whose values are never logged
+	DefaultRedactedParams = []string{"jwt", "iam"}
+)
+
+// redactedValue replaces the values of redacted query parameters
+const redactedValue = "REDACTED"
+
+// LoggingOptions configures AddAccessLogging
+type LoggingOptions struct {
+	// Fields are the fields of JSON access logs, DefaultLogFields when empty
+	Fields []string
+	// Format is the access log format, LogFormatJSON by default
+	Format LogFormat
+
+	// TrustedProxies are the IPs or CIDR ranges of the proxies whose X-Forwarded-For header is
+	// trusted to find the client IP. The header is ignored when empty.
+	// Response links trust the proxies set with response.SetTrustedProxies.
+	TrustedProxies []string
+	// RedactedParams are the query parameters whose values are redacted, DefaultRedactedParams when nil
+	RedactedParams []string
+
+	// SuccessSampling logs 1 in N 2xx and 3xx requests, all of them when 0 or 1
+	// Client and server errors, panics and slow requests are always logged.
+	SuccessSampling uint32
+	// SlowThreshold is the duration above which requests are always logged, flagged with "slow"
+	SlowThreshold time.Duration
+
+	// PanicStdout prints the panic stacktrace to stdout instead of logging it, useful for local development
+	PanicStdout bool
+
+	// Output is the writer of the logger given to AddAccessLogging
+	// When set, request loggers write to it and notice their error entries with all of their fields,
+	// such as the error and the url, as attributes. Otherwise only the message, request id and route
+	// are noticed, as logger hooks cannot read the fields of entries.
+	Output io.Writer
+}
+
 // AddLogging provides access logging for all requests
 // It will attempt to add TeachingStrategies specific fields where possible
 //    uid, vid
-// It implements the responsewriter interface to provide status logging as well
+// It wraps the response writer to provide status logging as well, see WrapResponseWriter
 // if panicStdout is true, the panic stacktrace will print to stdout and will not be logged, useful for local development
 func AddLogging(logger *zerolog.Logger, panicStdout bool) func(next http.Handler) http.Handler {
+	return AddAccessLogging(logger, LoggingOptions{PanicStdout: panicStdout})
+}
+
+// AddAccessLogging provides access logging for all requests, configured by opts
+// Every log entry, and the logs of panics, contain the request ID as "rid".
+// A request logger is put in the context for handlers, see utils.LoggerFromContext. Its errors
+// are noticed on the APM transaction of the request, which AddTracing must have started first.
+func AddAccessLogging(logger *zerolog.Logger, opts LoggingOptions) func(next http.Handler) http.Handler {
+
+	fields := opts.Fields
+	if len(fields) == 0 {
+		fields = DefaultLogFields
+	}
+	redacted := opts.RedactedParams
+	if redacted == nil {
+		redacted = DefaultRedactedParams
+	}
+	trusted := utils.ParseTrustedProxies(opts.TrustedProxies)
+
+	var successCount uint32
 
 	return func(next http.Handler) http.Handler {
 
@@ -27,68 +133,299 @@ func AddLogging(logger *zerolog.Logger, panicStdout bool) func(next http.Handler
 			// All log entries will contain a request ID
 			ctxLogger := logger.With().Str("rid", rid).Logger()
 
-			rec := statusRecorder{w, 200}
+			// Handlers log with the request logger, see utils.LoggerFromContext
+			reqLogger := requestLogger(ctxLogger, r, opts.Output)
+			r = r.WithContext(utils.NewLoggerContext(r.Context(), reqLogger))
+
+			rec := WrapResponseWriter(w)
+
+			var body *countingReader
+			if r.Body != nil && r.Body != http.NoBody {
+				body = &countingReader{ReadCloser: r.Body}
+				r.Body = body
+			}
 
 			reqStartTime := time.Now()
 
 			defer func() {
 
-				reqEndTime := time.Now()
-
-				accessLog := map[string]interface{}{
-					"remote_ip":  r.RemoteAddr,
-					"host":       r.Host,
-					"url":        r.URL.Path,
-					"proto":      r.Proto,
-					"method":     r.Method,
-					"user_agent": r.Header.Get("User-Agent"),
-					"dur_ms":     reqEndTime.Sub(reqStartTime).Nanoseconds() / 1000000,
-					"status":     rec.status,
+				e := recover()
+				status := rec.Status()
+				if e != nil {
+					status = 500
 				}
 
-				// If there are contextual data points present about the user
-				// that we would like to include, like the user id, and visitor id,
-				// we'll include them in the access log
-				_, claims, _ := tsjwt.FromContext(r.Context())
+				dur := time.Since(reqStartTime)
+				slow := opts.SlowThreshold > 0 && dur >= opts.SlowThreshold
 
-				accessLog["uid"] = claims.UserID
-				accessLog["vid"] = claims.VisitorID
+				sampled := e == nil && !slow && status < 400 && opts.SuccessSampling > 1 &&
+					atomic.AddUint32(&successCount, 1)%opts.SuccessSampling != 1
+				if !sampled {
+					entry := accessEntry{
+						r:        r,
+						rec:      rec,
+						status:   status,
+						dur:      dur,
+						remoteIP: clientIP(r, trusted),
+						query:    redactQuery(r.URL.RawQuery, redacted),
+					}
+					if body != nil {
+						entry.bytesIn = body.n
+					}
 
-				if e := recover(); e != nil {
-					accessLog["status"] = 500
-					ctxLogger.Info().Timestamp().Fields(accessLog).Msg("")
+					if opts.Format == LogFormatCombined {
+						ctxLogger.Info().Msg(entry.combined())
+					} else {
+						event := ctxLogger.Info().Timestamp().Fields(entry.fields(fields))
+						if slow {
+							event = event.Bool("slow", true)
+						}
+						event.Msg("")
+					}
+				}
+
+				if e != nil && e != http.ErrAbortHandler {
 					stackBytes := debug.Stack()
 
 					baseLog := ctxLogger.Error().Timestamp().Interface("recover_info", e)
-					if panicStdout {
+					if opts.PanicStdout {
 						fmt.Println(string(stackBytes))
 						baseLog.Msg("panic_on_request")
 					} else {
 						baseLog.Bytes("debug_stack", stackBytes).Msg("panic_on_request")
 					}
+				}
 
+				if e != nil {
 					// propagate the panic
 					panic(e)
 				}
 
-				ctxLogger.Info().Timestamp().Fields(accessLog).Msg("")
-
 			}()
 
-			next.ServeHTTP(&rec, r)
+			next.ServeHTTP(rec, r)
 		}
 		return http.HandlerFunc(fn)
 	}
 }
 
-// statusRecorder implements the response writer interface
-// it tracks the status code for logging later
-type statusRecorder struct {
-	http.ResponseWriter
-	status int
+// requestLogger returns the logger of a request, adding the user and visitor ids when the JWT is
+// already verified (tsjwt.Verify adds them otherwise) and the route pattern to every entry
+// Its error entries are noticed on the APM transaction of the request, with their fields when
+// the output of the logger is given.
+func requestLogger(logger zerolog.Logger, r *http.Request, output io.Writer) *zerolog.Logger {
+	var reqLogger zerolog.Logger
+	if output != nil {
+		notice := func(err error) { apm.FromContext(r.Context()).NoticeError(err) }
+		reqLogger = logger.Output(errorWriter{w: output, notice: notice}).Hook(routeHook{r})
+	} else {
+		reqLogger = logger.Hook(routeHook{r}).Hook(requestErrorHook{r})
+	}
+
+	if token, claims, err := tsjwt.FromContext(r.Context()); token != nil && err == nil {
+		reqLogger = reqLogger.With().Int64("uid", claims.UserID).Int64("vid", claims.VisitorID).Logger()
+	}
+
+	return &reqLogger
+}
+
+// routeHook adds the route pattern matched so far to log entries
+type routeHook struct {
+	r *http.Request
+}
+
+func (h routeHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if route := routePattern(h.r); route != "" {
+		e.Str("route", route)
+	}
+}
+
+// accessEntry is the access log of a served request
+type accessEntry struct {
+	r        *http.Request
+	rec      ResponseWriter
+	status   int
+	dur      time.Duration
+	remoteIP string
+	query    string
+	bytesIn  int64
+}
+
+// fields returns the selected fields of the entry
+func (e accessEntry) fields(names []string) map[string]interface{} {
+	accessLog := make(map[string]interface{}, len(names))
+
+	for _, name := range names {
+		switch name {
+		case LogFieldRemoteIP:
+			accessLog[name] = e.remoteIP
+		case LogFieldHost:
+			accessLog[name] = e.r.Host
+		case LogFieldURL:
+			accessLog[name] = e.r.URL.Path
+		case LogFieldQuery:
+			if e.query != "" {
+				accessLog[name] = e.query
+			}
+		case LogFieldProto:
+			accessLog[name] = e.r.Proto
+		case LogFieldMethod:
+			accessLog[name] = e.r.Method
+		case LogFieldUserAgent:
+			accessLog[name] = e.r.Header.Get("User-Agent")
+		case LogFieldDuration:
+			accessLog[name] = e.dur.Nanoseconds() / 1000000
+		case LogFieldTTFB:
+			accessLog[name] = e.rec.TimeToFirstByte().Nanoseconds() / 1000000
+		case LogFieldStatus:
+			accessLog[name] = e.status
+		case LogFieldUserID, LogFieldVisitorID:
+			// If there are contextual data points present about the user
+			// that we would like to include, like the user id, and visitor id,
+			// we'll include them in the access log
+			_, claims, _ := tsjwt.FromContext(e.r.Context())
+			if name == LogFieldUserID {
+				accessLog[name] = claims.UserID
+			} else {
+				accessLog[name] = claims.VisitorID
+			}
+		case LogFieldBytesIn:
+			accessLog[name] = e.bytesIn
+		case LogFieldBytesOut:
+			accessLog[name] = e.rec.BytesWritten()
+		case LogFieldRoute:
+			if route := routePattern(e.r); route != "" {
+				accessLog[name] = route
+			}
+		case LogFieldReferer:
+			if referer := e.r.Referer(); referer != "" {
+				accessLog[name] = referer
+			}
+		case LogFieldLocale:
+			if locale := routeParam(e.r, "locale"); locale != "" {
+				accessLog[name] = locale
+			}
+		case LogFieldOrigin:
+			if origin := e.r.Header.Get("Origin"); origin != "" {
+				accessLog[name] = origin
+			}
+		}
+	}
+
+	return accessLog
+}
+
+// combined formats the entry in the Apache combined log format
+func (e accessEntry) combined() string {
+	uri := e.r.URL.Path
+	if e.query != "" {
+		uri += "?" + e.query
+	}
+
+	user := "-"
+	if _, claims, _ := tsjwt.FromContext(e.r.Context()); claims.UserID != 0 {
+		user = strconv.FormatInt(claims.UserID, 10)
+	}
+
+	size := "-"
+	if e.rec.BytesWritten() > 0 {
+		size = strconv.FormatInt(e.rec.BytesWritten(), 10)
+	}
+
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s %q %q`,
+		e.remoteIP, user, time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+		e.r.Method, uri, e.r.Proto, e.status, size,
+		orDash(e.r.Referer()), orDash(e.r.UserAgent()))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// routePattern returns the chi route pattern matched by a request, once served
+func routePattern(r *http.Request) string {
+	if rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context); ok && rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
+
+// routeParam returns a chi URL parameter matched by a request, once served
+func routeParam(r *http.Request, key string) string {
+	if rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context); ok && rctx != nil {
+		return rctx.URLParam(key)
+	}
+	return ""
+}
+
+// clientIP returns the IP of the client, without port
+// When the request comes from a trusted proxy, X-Forwarded-For is walked from the right,
+// the client being the first address that is not a trusted proxy. Otherwise, or when the
+// header is missing, it is the IP of the remote address.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !utils.IsTrustedProxy(ip, trusted) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	client := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		client = hops[i]
+		if !utils.IsTrustedProxy(ip, trusted) {
+			break
+		}
+	}
+	return client
+}
+
+// redactQuery replaces the values of secret query parameters
+func redactQuery(rawQuery string, redacted []string) string {
+	if rawQuery == "" || len(redacted) == 0 {
+		return rawQuery
+	}
+
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		rawKey := strings.SplitN(part, "=", 2)[0]
+		key := rawKey
+		if name, err := url.QueryUnescape(rawKey); err == nil {
+			key = name
+		}
+		for _, param := range redacted {
+			if strings.EqualFold(key, param) {
+				parts[i] = rawKey + "=" + redactedValue
+				break
+			}
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
 }
 
-func (rec *statusRecorder) WriteHeader(code int) {
-	rec.status = code
-	rec.ResponseWriter.WriteHeader(code)
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddAccessLogging(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	router := chi.NewRouter()
+	router.Use(AddAccessLogging(&logger, LoggingOptions{TrustedProxies: []string{"10.0.0.0/8"}}))
+	router.Post("/{locale}/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		body := new(bytes.Buffer)
+		body.ReadFrom(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/en-us/users/42?jwt=secret&page=2&IAM=token", strings.NewReader(`{"a":1}`))
+	r.RemoteAddr = "10.0.0.2:5555"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	r.Header.Set("Referer", "https://app.example.com/")
+	r.Header.Set("Origin", "https://app.example.com")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+
+	assert.Equal(t, "203.0.113.7", entry["remote_ip"])
+	assert.Equal(t, "/en-us/users/42", entry["url"])
+	assert.Equal(t, "jwt=REDACTED&page=2&IAM=REDACTED", entry["query"])
+	assert.Equal(t, float64(201), entry["status"])
+	assert.Equal(t, float64(7), entry["bytes_in"])
+	assert.Equal(t, float64(7), entry["bytes_out"])
+	assert.Equal(t, "/{locale}/users/{id}", entry["route"])
+	assert.Equal(t, "en-us", entry["locale"])
+	assert.Equal(t, "https://app.example.com/", entry["referer"])
+	assert.Equal(t, "https://app.example.com", entry["origin"])
+	assert.NotContains(t, logs.String(), "secret")
+}
+
+func TestAddAccessLoggingSampling(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	statuses := []int{200, 200, 200, 404, 200, 500, 200, 200}
+	next := 0
+	handler := AddAccessLogging(&logger, LoggingOptions{SuccessSampling: 3, Fields: []string{LogFieldStatus}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(statuses[next])
+			next++
+		}))
+
+	for range statuses {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	var logged []int
+	decoder := json.NewDecoder(&logs)
+	for decoder.More() {
+		var entry struct{ Status int }
+		assert.NoError(t, decoder.Decode(&entry))
+		logged = append(logged, entry.Status)
+	}
+
+	// 1 in 3 successes, every error
+	assert.Equal(t, []int{200, 404, 200, 500}, logged)
+}
+
+func TestAddAccessLoggingSlow(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	handler := AddAccessLogging(&logger, LoggingOptions{SuccessSampling: 1000, SlowThreshold: time.Millisecond, Fields: []string{LogFieldStatus}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(2 * time.Millisecond)
+		}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, 2, strings.Count(logs.String(), `"slow":true`))
+}
+
+func TestAddAccessLoggingCombined(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	handler := AddAccessLogging(&logger, LoggingOptions{Format: LogFormatCombined})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		}))
+
+	r := httptest.NewRequest(http.MethodGet, "/users?iam=token", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "curl/7.64")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+
+	message, _ := entry["message"].(string)
+	assert.True(t, strings.HasPrefix(message, "192.0.2.1 - - ["), message)
+	assert.True(t, strings.HasSuffix(message, `] "GET /users?iam=REDACTED HTTP/1.1" 200 5 "-" "curl/7.64"`), message)
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := utils.ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.10"})
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		trusted    bool
+		expected   string
+	}{
+		{"10.0.0.2:80", "203.0.113.7", true, "203.0.113.7"},
+		{"10.0.0.2:80", "198.51.100.1, 203.0.113.7, 10.0.0.5", true, "203.0.113.7"},
+		{"192.0.2.10:80", "203.0.113.7", true, "203.0.113.7"},
+		{"10.0.0.2:80", "", true, "10.0.0.2"},
+		{"10.0.0.2:80", "10.0.0.3", true, "10.0.0.3"},
+		{"198.51.100.9:80", "203.0.113.7", true, "198.51.100.9"},
+		{"10.0.0.2:80", "203.0.113.7", false, "10.0.0.2"},
+		{"[2001:db8::1]:80", "", false, "2001:db8::1"},
+		{"unix", "", false, "unix"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+
+		proxies := trusted
+		if !test.trusted {
+			proxies = nil
+		}
+		assert.Equal(t, test.expected, clientIP(r, proxies), test.remoteAddr+" "+test.forwarded)
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID, AddAccessLogging(&logger, LoggingOptions{Fields: []string{LogFieldStatus}}))
+	router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		reqLogger, ok := utils.LoggerFromContext(r.Context())
+		assert.True(t, ok)
+		reqLogger.Info().Msg("loading user")
+		assert.True(t, zerolog.Ctx(r.Context()) == reqLogger)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	decoder := json.NewDecoder(&logs)
+	var handlerLog, accessLog map[string]interface{}
+	assert.NoError(t, decoder.Decode(&handlerLog))
+	assert.NoError(t, decoder.Decode(&accessLog))
+
+	assert.Equal(t, "loading user", handlerLog["message"])
+	assert.Equal(t, "/users/{id}", handlerLog["route"])
+	assert.NotEmpty(t, handlerLog["rid"])
+	assert.Equal(t, handlerLog["rid"], accessLog["rid"])
+
+	_, ok := utils.LoggerFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context())
+	assert.False(t, ok)
+}
@@ -11,13 +11,18 @@ import (
 // originTagKey is the context key used to retrieve the request's origin
 const originTagKey = bootstrapContextKey("origin")
 
-// OriginFromContext returns the origin value from the given context
+// OriginFromContext returns the origin value from the given context,
+// or "" when the request had no allowed origin
 func OriginFromContext(ctx context.Context) string {
-	return ctx.Value(originTagKey).(string)
+	origin, _ := ctx.Value(originTagKey).(string)
+	return origin
 }
 
 // ValidateOriginMiddleware checks if the incoming request is from an allowed Origin.
 // If the origin is allowed, it will be added to the request context as "origin"
+//
+// Deprecated: use Cors, which also answers preflight requests and sets the CORS headers.
+// Responses must then not be created with response.NewWithCors.
 func ValidateOriginMiddleware(allowedOrigins []string, logger *zerolog.Logger) func(next http.Handler) http.Handler {
 	originsMap := make(map[string]struct{}, len(allowedOrigins))
 
@@ -1,45 +1,66 @@
 package middleware
 
 import (
-	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 
-	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"github.com/go-chi/chi/middleware"
 	newrelic "github.com/newrelic/go-agent"
 	"github.com/rs/zerolog"
 )
 
 // AddProfiling automatically adds timing, and recovery error notices to
 // wrapped routes
-func AddProfiling(apm newrelic.Application) func(next http.Handler) http.Handler {
+// It reports to NewRelic, see AddTracing for other APMs.
+func AddProfiling(app newrelic.Application) func(next http.Handler) http.Handler {
+	return AddTracing(apm.NewRelic(app))
+}
+
+// AddTracing starts an APM transaction for every request, recording its status code and
+// the errors of recovered panics
+// The transaction is injected in to the context so that functions within the processing
+// flow may segment it as warranted, see apm.FromContext. It is named after the route pattern
+// once the request is served, see apm.RouteName, and carries the request id when
+// middleware.RequestID runs first. The uid, vid and locale attributes are added by
+// tsjwt.Verify and AddLocale.
+func AddTracing(tracer apm.Tracer) func(next http.Handler) http.Handler {
 
 	return func(next http.Handler) http.Handler {
 
 		fn := func(w http.ResponseWriter, r *http.Request) {
 
 			// Begin our transaction.  As of this point, we are tracking the execution time
-			// of the request.  The transaction is injected in to the context so that
-			// functions within the processing flow may segment the transaction as warranted
-			txn := apm.StartTransaction(r.URL.Path, w, r)
-			augmentedContext := context.WithValue(r.Context(), utils.ContextKey("txn"), txn)
+			// of the request.
+			txn, ctx := tracer.StartTransaction(w, r)
+			if rid := middleware.GetReqID(ctx); rid != "" {
+				txn.AddAttribute(apm.AttributeRequestID, rid)
+			}
+			ww := WrapResponseWriter(w)
 
-			defer func(txn newrelic.Transaction) {
+			defer func() {
 
 				// If this was a panic, add telemetry.
+				// Panics are usually answered by Recover first, this is for those of other middlewares.
 				if e := recover(); e != nil {
 
-					txn.NoticeError(e.(error))
+					if e != http.ErrAbortHandler {
+						txn.NoticeError(panicError(e))
+					}
+					txn.AddAttribute(apm.AttributeStatusCode, http.StatusInternalServerError)
 					txn.End()
 
 					// propagate the panic
 					panic(e)
 
 				}
+				txn.AddAttribute(apm.AttributeStatusCode, ww.Status())
 				txn.End()
-			}(txn)
+			}()
 
-			next.ServeHTTP(w, r.WithContext(augmentedContext))
+			next.ServeHTTP(ww, r.WithContext(ctx))
 		}
 		return http.HandlerFunc(fn)
 	}
@@ -47,6 +68,9 @@ func AddProfiling(apm newrelic.Application) func(next http.Handler) http.Handler
 
 // NoticeErrorHook allows us to publish errors to our APM of choice
 // In this case NewRelic
+//
+// Deprecated: request loggers notice their errors on the request transaction, see
+// utils.LoggerFromContext, and ErrorHook notices the errors of other loggers.
 type NoticeErrorHook struct {
 	Txn newrelic.Transaction
 }
@@ -57,3 +81,134 @@ func (h NoticeErrorHook) Run(e *zerolog.Event, level zerolog.Level, msg string)
 		h.Txn.NoticeError(errors.New(msg))
 	}
 }
+
+// BackgroundErrorTransaction names the transactions of ErrorWriter
+const BackgroundErrorTransaction = "log_error"
+
+// ErrorWriter writes log entries to w, noticing the error, fatal and panic entries on the APM,
+// each in its own background transaction, with the fields of the entry as attributes
+// It is meant for loggers used outside requests, such as the global log.Logger, e.g.
+//    log.Logger = zerolog.New(middleware.ErrorWriter(os.Stdout, tracer))
+// Request loggers already notice their errors on the request transaction, see LoggingOptions.Output.
+//
+// Every error entry starts and reports a transaction, which the APM ingests and bills like those of
+// requests: a loop logging an error per item produces as many transactions. Log such errors once,
+// at a lower level, or sample the error level of the logger, see zerolog.LevelSampler.
+func ErrorWriter(w io.Writer, tracer apm.Tracer) zerolog.LevelWriter {
+	return errorWriter{w: w, notice: func(err error) {
+		txn := tracer.StartBackgroundTransaction(BackgroundErrorTransaction)
+		txn.NoticeError(err)
+		txn.End()
+	}}
+}
+
+// errorWriter writes log entries to w, passing the error of error, fatal and panic entries to notice
+type errorWriter struct {
+	w      io.Writer
+	notice func(err error)
+}
+
+func (ew errorWriter) Write(p []byte) (int, error) {
+	return ew.w.Write(p)
+}
+
+func (ew errorWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if isErrorLevel(level) {
+		ew.notice(entryError(level, p))
+	}
+
+	if lw, ok := ew.w.(zerolog.LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return ew.w.Write(p)
+}
+
+// requestErrorHook notices the error logs of a request logger on the request transaction,
+// with the request id and route as attributes
+// Hooks cannot read the fields of entries, request loggers writing to LoggingOptions.Output notice
+// their errors with an errorWriter instead.
+type requestErrorHook struct {
+	r *http.Request
+}
+
+func (h requestErrorHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if !isErrorLevel(level) {
+		return
+	}
+
+	attributes := map[string]interface{}{}
+	if rid := middleware.GetReqID(h.r.Context()); rid != "" {
+		attributes["rid"] = rid
+	}
+	if route := routePattern(h.r); route != "" {
+		attributes["route"] = route
+	}
+
+	apm.FromContext(h.r.Context()).NoticeError(logError(level, msg, attributes))
+}
+
+func isErrorLevel(level zerolog.Level) bool {
+	return level >= zerolog.ErrorLevel && level <= zerolog.PanicLevel
+}
+
+// entryError is the error noticed for a JSON log entry
+// The error text is appended to the message and the other fields, except the level and time, are attributes.
+func entryError(level zerolog.Level, p []byte) newrelic.Error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return logError(level, "", nil)
+	}
+
+	msg, _ := fields[zerolog.MessageFieldName].(string)
+	if errText, ok := fields[zerolog.ErrorFieldName].(string); ok && errText != "" {
+		if msg == "" {
+			msg = errText
+		} else {
+			msg += ": " + errText
+		}
+	}
+
+	attributes := map[string]interface{}{}
+	for key, value := range fields {
+		switch key {
+		case zerolog.MessageFieldName, zerolog.LevelFieldName, zerolog.TimestampFieldName:
+			continue
+		}
+		if value = attributeValue(value); value != nil {
+			attributes[key] = value
+		}
+	}
+
+	return logError(level, msg, attributes)
+}
+
+// attributeValue converts a decoded JSON value to an APM attribute value,
+// objects and arrays are kept as JSON and nulls are dropped
+func attributeValue(value interface{}) interface{} {
+	switch value.(type) {
+	case nil:
+		return nil
+	case string, float64, bool:
+		return value
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// logError is the error noticed for a log entry, classed by level
+func logError(level zerolog.Level, msg string, attributes map[string]interface{}) newrelic.Error {
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+	attributes["level"] = level.String()
+
+	if msg == "" {
+		msg = level.String()
+	}
+
+	return newrelic.Error{Message: msg, Class: "log." + level.String(), Attributes: attributes}
+}
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	newrelic "github.com/newrelic/go-agent"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingTracer hands out a single recordingTransaction
+type recordingTracer struct {
+	txn *recordingTransaction
+}
+
+func (t *recordingTracer) StartTransaction(w http.ResponseWriter, r *http.Request) (apm.Transaction, context.Context) {
+	t.txn = &recordingTransaction{attributes: map[string]interface{}{}}
+	return t.txn, apm.NewContext(r.Context(), t.txn)
+}
+
+func (t *recordingTracer) StartBackgroundTransaction(name string) apm.Transaction {
+	t.txn = &recordingTransaction{name: name, attributes: map[string]interface{}{}}
+	return t.txn
+}
+
+func (t *recordingTracer) Shutdown(time.Duration) {}
+
+type recordingTransaction struct {
+	name       string
+	attributes map[string]interface{}
+	errors     []error
+	ended      bool
+}
+
+func (t *recordingTransaction) SetName(string)                                         {}
+func (t *recordingTransaction) AddAttribute(key string, value interface{})             { t.attributes[key] = value }
+func (t *recordingTransaction) NoticeError(err error)                                  { t.errors = append(t.errors, err) }
+func (t *recordingTransaction) StartSegment(string) apm.Segment                        { return t }
+func (t *recordingTransaction) StartDatastoreSegment(apm.DatastoreSegment) apm.Segment { return t }
+func (t *recordingTransaction) StartExternalSegment(*http.Request) apm.Segment         { return t }
+func (t *recordingTransaction) End()                                                   { t.ended = true }
+
+func TestAddTracing(t *testing.T) {
+	tests := []struct {
+		handler http.HandlerFunc
+		status  int
+	}{
+		{func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, http.StatusOK},
+		{func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) }, http.StatusBadGateway},
+		{func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		tracer := &recordingTracer{}
+		var inContext apm.Transaction
+
+		handler := AddTracing(tracer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inContext = apm.FromContext(r.Context())
+			test.handler(w, r)
+		}))
+		middleware.RequestID(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.True(t, inContext == apm.Transaction(tracer.txn))
+		assert.True(t, tracer.txn.ended)
+		assert.Equal(t, test.status, tracer.txn.attributes[apm.AttributeStatusCode])
+		assert.NotEmpty(t, tracer.txn.attributes[apm.AttributeRequestID])
+	}
+}
+
+func TestRequestLoggerNoticesErrors(t *testing.T) {
+	tracer := &recordingTracer{}
+	logger := zerolog.New(ioutil.Discard)
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID, AddTracing(tracer), AddLogging(&logger, false))
+	router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		reqLogger, _ := utils.LoggerFromContext(r.Context())
+		reqLogger.Info().Msg("loading user")
+		reqLogger.Warn().Msg("slow user")
+		reqLogger.Error().Err(errors.New("no rows")).Msg("unable to load user")
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	if assert.Len(t, tracer.txn.errors, 1) {
+		err, ok := tracer.txn.errors[0].(newrelic.Error)
+		assert.True(t, ok)
+		assert.Equal(t, "unable to load user", err.Message)
+		assert.Equal(t, "log.error", err.Class)
+		assert.Equal(t, "/users/{id}", err.Attributes["route"])
+		assert.NotEmpty(t, err.Attributes["rid"])
+	}
+}
+
+func TestRequestLoggerNoticesErrorFields(t *testing.T) {
+	tracer := &recordingTracer{}
+	var out bytes.Buffer
+	logger := zerolog.New(&out)
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID, AddTracing(tracer), AddAccessLogging(&logger, LoggingOptions{Output: &out}))
+	router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		reqLogger, _ := utils.LoggerFromContext(r.Context())
+		reqLogger.Warn().Msg("slow user")
+		reqLogger.Error().Err(errors.New("no rows")).Str("uri", r.RequestURI).Int("attempt", 2).Msg("unable to load user")
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	if assert.Len(t, tracer.txn.errors, 1) {
+		err, ok := tracer.txn.errors[0].(newrelic.Error)
+		assert.True(t, ok)
+		assert.Equal(t, "unable to load user: no rows", err.Message)
+		assert.Equal(t, "log.error", err.Class)
+		assert.Equal(t, "no rows", err.Attributes["error"])
+		assert.Equal(t, "/users/42", err.Attributes["uri"])
+		assert.Equal(t, float64(2), err.Attributes["attempt"])
+		assert.Equal(t, "/users/{id}", err.Attributes["route"])
+		assert.Equal(t, "error", err.Attributes["level"])
+		assert.NotEmpty(t, err.Attributes["rid"])
+		assert.NotContains(t, err.Attributes, "message")
+	}
+	assert.Contains(t, out.String(), `"message":"unable to load user"`, "entries are still written")
+}
+
+func TestErrorWriter(t *testing.T) {
+	tests := []struct {
+		log     func(l zerolog.Logger)
+		noticed bool
+	}{
+		{func(l zerolog.Logger) { l.Info().Msg("started") }, false},
+		{func(l zerolog.Logger) { l.Warn().Msg("retrying") }, false},
+		{func(l zerolog.Logger) { l.Error().Err(errors.New("timeout")).Str("job", "sync").Msg("job failed") }, true},
+	}
+
+	for _, test := range tests {
+		tracer := &recordingTracer{}
+		var out bytes.Buffer
+		test.log(zerolog.New(ErrorWriter(&out, tracer)))
+
+		assert.NotEmpty(t, out.String(), "entries are written")
+		if !test.noticed {
+			assert.Nil(t, tracer.txn)
+			continue
+		}
+		if assert.NotNil(t, tracer.txn) {
+			assert.Equal(t, BackgroundErrorTransaction, tracer.txn.name)
+			assert.True(t, tracer.txn.ended)
+			if assert.Len(t, tracer.txn.errors, 1) {
+				err := tracer.txn.errors[0].(newrelic.Error)
+				assert.Equal(t, "job failed: timeout", err.Message)
+				assert.Equal(t, "sync", err.Attributes["job"])
+			}
+		}
+	}
+}
+
+func TestEntryError(t *testing.T) {
+	err := entryError(zerolog.ErrorLevel, []byte(`{"level":"error","time":"now","error":"boom","ids":[1,2],"user":{"id":4},"none":null}`))
+	assert.Equal(t, "boom", err.Message)
+	assert.Equal(t, map[string]interface{}{"error": "boom", "ids": "[1,2]", "user": `{"id":4}`, "level": "error"}, err.Attributes)
+
+	err = entryError(zerolog.FatalLevel, []byte("not json"))
+	assert.Equal(t, "fatal", err.Message)
+	assert.Equal(t, "log.fatal", err.Class)
+}
@@ -1,21 +1,96 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
+	"runtime/debug"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apperr"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
-func Recoverer(next http.Handler) http.Handler {
+// PanicError is a recovered panic
+type PanicError struct {
+	// Value is the value the handler panicked with
+	Value interface{}
+	// Stack is the stack trace of the panic
+	Stack []byte
+}
 
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if rvr := recover(); rvr != nil {
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			}
-		}()
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
 
-		next.ServeHTTP(w, r)
+// Unwrap returns the value of panics with an error
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
 	}
+	return nil
+}
+
+// Recoverer recovers from panics, see Recover, logging them with the global log.Logger
+func Recoverer(next http.Handler) http.Handler {
+	return Recover(&log.Logger)(next)
+}
+
+// Recover recovers from panics, converting them to a *PanicError which is logged with its stack
+// and the request id, and noticed on the APM transaction of the request
+// A 500 StandardResponse is sent unless the response was already started, in which case it panics
+// with http.ErrAbortHandler for net/http to abort the response, so that the client does not take
+// what was written for a complete response. http.ErrAbortHandler panics are propagated as is.
+// To report to the APM and answer through compression, it runs after AddTracing and AddCompression.
+func Recover(logger *zerolog.Logger) func(next http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
 
-	return http.HandlerFunc(fn)
+			ww := WrapResponseWriter(w)
 
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+				if rvr == http.ErrAbortHandler {
+					panic(rvr)
+				}
+
+				err := &PanicError{Value: rvr, Stack: debug.Stack()}
+
+				logger.Error().Timestamp().
+					Str("rid", middleware.GetReqID(r.Context())).
+					Str("route", routePattern(r)).
+					Interface("recover_info", rvr).
+					Bytes("debug_stack", err.Stack).
+					Msg("panic_on_request")
+
+				apm.FromContext(r.Context()).NoticeError(err)
+
+				if ww.Written() {
+					panic(http.ErrAbortHandler)
+				}
+				if sendErr := response.SendFor(ww, r, apperr.Internal(err).Response()); sendErr != nil {
+					logger.Error().Timestamp().Str("rid", middleware.GetReqID(r.Context())).Err(sendErr).Msg("unable to send error response")
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// panicError converts a recovered value to an error
+func panicError(rvr interface{}) error {
+	if err, ok := rvr.(error); ok {
+		return err
+	}
+	return &PanicError{Value: rvr}
 }
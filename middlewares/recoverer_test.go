@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		body    string
+		cause   error
+		aborted bool
+	}{
+		{
+			"string panic",
+			func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			http.StatusInternalServerError,
+			`{"message":"Internal Server Error","status":"server error"}`,
+			nil,
+			false,
+		},
+		{
+			"error panic",
+			func(w http.ResponseWriter, r *http.Request) { panic(errors.New("nil map")) },
+			http.StatusInternalServerError,
+			`{"message":"Internal Server Error","status":"server error"}`,
+			errors.New("nil map"),
+			false,
+		},
+		{
+			"response started",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte("partial"))
+				panic("boom")
+			},
+			http.StatusAccepted,
+			"partial",
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		var logs bytes.Buffer
+		logger := zerolog.New(&logs)
+		tracer := &recordingTracer{}
+
+		router := chi.NewRouter()
+		router.Use(middleware.RequestID, AddTracing(tracer), Recover(&logger))
+		router.Get("/users/{id}", test.handler)
+
+		w := httptest.NewRecorder()
+		serve := func() { router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/42", nil)) }
+		if test.aborted {
+			assert.PanicsWithValue(t, http.ErrAbortHandler, serve, test.name)
+		} else {
+			assert.NotPanics(t, serve, test.name)
+		}
+
+		assert.Equal(t, test.status, w.Code, test.name)
+		assert.Equal(t, test.body, w.Body.String(), test.name)
+
+		assert.Contains(t, logs.String(), `"message":"panic_on_request"`, test.name)
+		assert.Contains(t, logs.String(), `"rid":"`, test.name)
+		assert.Contains(t, logs.String(), `"route":"/users/{id}"`, test.name)
+		assert.Contains(t, logs.String(), `"debug_stack":`, test.name)
+
+		if assert.Len(t, tracer.txn.errors, 1, test.name) {
+			panicErr, ok := tracer.txn.errors[0].(*PanicError)
+			assert.True(t, ok, test.name)
+			assert.NotEmpty(t, panicErr.Stack, test.name)
+			assert.Equal(t, test.cause, panicErr.Unwrap(), test.name)
+		}
+		if test.aborted {
+			assert.Equal(t, http.StatusInternalServerError, tracer.txn.attributes["http.status_code"], test.name)
+		} else {
+			assert.Equal(t, test.status, tracer.txn.attributes["http.status_code"], test.name)
+		}
+	}
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+	logger := zerolog.Nop()
+	tracer := &recordingTracer{}
+
+	handler := AddTracing(tracer)(Recover(&logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})))
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	assert.Empty(t, tracer.txn.errors)
+	assert.True(t, tracer.txn.ended)
+}
+
+func TestAddTracingPanic(t *testing.T) {
+	tracer := &recordingTracer{}
+
+	handler := AddTracing(tracer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("not an error")
+	}))
+
+	assert.PanicsWithValue(t, "not an error", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	if assert.Len(t, tracer.txn.errors, 1) {
+		assert.EqualError(t, tracer.txn.errors[0], "panic: not an error")
+	}
+	assert.True(t, tracer.txn.ended)
+}
+
+func TestRecoverNegotiatesProblem(t *testing.T) {
+	logger := zerolog.Nop()
+
+	handler := Recover(&logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/problem+json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"status":500`)
+}
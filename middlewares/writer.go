@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseWriter records the status, size and time to first byte of a response
+// Middlewares share a single ResponseWriter per request, see WrapResponseWriter.
+type ResponseWriter interface {
+	http.ResponseWriter
+	// Status returns the status code sent, http.StatusOK when the handler wrote nothing,
+	// as net/http then answers with it
+	Status() int
+	// Written reports whether the header was sent, explicitly or by a first Write or Flush
+	Written() bool
+	// BytesWritten returns the number of body bytes written
+	BytesWritten() int64
+	// TimeToFirstByte returns the duration between the wrapping and the header being sent,
+	// zero while it is not
+	TimeToFirstByte() time.Duration
+	// Unwrap returns the wrapped http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+// WrapResponseWriter wraps w to record its response, or returns w when it already is a ResponseWriter
+// The wrapper implements http.Flusher, http.Hijacker and http.Pusher only when w does, so that
+// handlers detecting them keep working behind logging, metrics and profiling.
+func WrapResponseWriter(w http.ResponseWriter) ResponseWriter {
+	if rw, ok := w.(ResponseWriter); ok {
+		return rw
+	}
+
+	rec := &recorder{ResponseWriter: w, start: time.Now()}
+
+	_, fl := w.(http.Flusher)
+	_, hj := w.(http.Hijacker)
+	_, ps := w.(http.Pusher)
+
+	switch {
+	case fl && hj && ps:
+		return struct {
+			*recorder
+			flusher
+			hijacker
+			pusher
+		}{rec, flusher{rec}, hijacker{rec}, pusher{rec}}
+	case fl && hj:
+		return struct {
+			*recorder
+			flusher
+			hijacker
+		}{rec, flusher{rec}, hijacker{rec}}
+	case fl && ps:
+		return struct {
+			*recorder
+			flusher
+			pusher
+		}{rec, flusher{rec}, pusher{rec}}
+	case hj && ps:
+		return struct {
+			*recorder
+			hijacker
+			pusher
+		}{rec, hijacker{rec}, pusher{rec}}
+	case fl:
+		return struct {
+			*recorder
+			flusher
+		}{rec, flusher{rec}}
+	case hj:
+		return struct {
+			*recorder
+			hijacker
+		}{rec, hijacker{rec}}
+	case ps:
+		return struct {
+			*recorder
+			pusher
+		}{rec, pusher{rec}}
+	}
+
+	return rec
+}
+
+// recorder is the ResponseWriter of writers implementing no optional interface
+type recorder struct {
+	http.ResponseWriter
+
+	status    int
+	bytes     int64
+	start     time.Time
+	firstByte time.Time
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.firstByte.IsZero() {
+		rec.status = code
+		// informational responses precede the final header
+		if code >= http.StatusOK || code == http.StatusSwitchingProtocols {
+			rec.firstByte = time.Now()
+		}
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.implicitHeader()
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// implicitHeader records the 200 sent by a Write or Flush without WriteHeader
+func (rec *recorder) implicitHeader() {
+	if rec.firstByte.IsZero() {
+		rec.status = http.StatusOK
+		rec.firstByte = time.Now()
+	}
+}
+
+func (rec *recorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+func (rec *recorder) Written() bool {
+	return !rec.firstByte.IsZero()
+}
+
+func (rec *recorder) BytesWritten() int64 {
+	return rec.bytes
+}
+
+func (rec *recorder) TimeToFirstByte() time.Duration {
+	if rec.firstByte.IsZero() {
+		return 0
+	}
+	return rec.firstByte.Sub(rec.start)
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+type flusher struct {
+	rec *recorder
+}
+
+func (f flusher) Flush() {
+	f.rec.implicitHeader()
+	f.rec.ResponseWriter.(http.Flusher).Flush()
+}
+
+type hijacker struct {
+	rec *recorder
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.rec.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+type pusher struct {
+	rec *recorder
+}
+
+func (p pusher) Push(target string, opts *http.PushOptions) error {
+	return p.rec.ResponseWriter.(http.Pusher).Push(target, opts)
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// basicWriter implements no optional interface
+type basicWriter struct {
+	http.ResponseWriter
+}
+
+// hijackPushWriter implements http.Hijacker and http.Pusher but not http.Flusher
+type hijackPushWriter struct {
+	http.ResponseWriter
+	pushed string
+}
+
+func (w *hijackPushWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil }
+func (w *hijackPushWriter) Push(target string, opts *http.PushOptions) error {
+	w.pushed = target
+	return nil
+}
+
+// fancyWriter implements http.Flusher, http.Hijacker and http.Pusher
+type fancyWriter struct {
+	*httptest.ResponseRecorder
+	hijackPushWriter
+}
+
+func TestWrapResponseWriterInterfaces(t *testing.T) {
+	tests := []struct {
+		name                      string
+		w                         http.ResponseWriter
+		flusher, hijacker, pusher bool
+	}{
+		{"basic", basicWriter{httptest.NewRecorder()}, false, false, false},
+		{"flusher", httptest.NewRecorder(), true, false, false},
+		{"hijacker and pusher", &hijackPushWriter{ResponseWriter: httptest.NewRecorder()}, false, true, true},
+		{"all", &fancyWriter{ResponseRecorder: httptest.NewRecorder()}, true, true, true},
+	}
+
+	for _, test := range tests {
+		ww := WrapResponseWriter(test.w)
+
+		_, fl := ww.(http.Flusher)
+		_, hj := ww.(http.Hijacker)
+		_, ps := ww.(http.Pusher)
+
+		assert.Equal(t, test.flusher, fl, test.name)
+		assert.Equal(t, test.hijacker, hj, test.name)
+		assert.Equal(t, test.pusher, ps, test.name)
+		assert.True(t, ww.Unwrap() == test.w, test.name)
+		assert.True(t, WrapResponseWriter(ww) == ww, test.name)
+	}
+}
+
+func TestWrapResponseWriterRecording(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter)
+		status  int
+		written bool
+		bytes   int64
+	}{
+		{"nothing", func(w http.ResponseWriter) {}, http.StatusOK, false, 0},
+		{"implicit 200", func(w http.ResponseWriter) { w.Write([]byte("hello")) }, http.StatusOK, true, 5},
+		{"explicit", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("a"))
+			w.Write([]byte("bc"))
+		}, http.StatusCreated, true, 3},
+		{"superfluous WriteHeader", func(w http.ResponseWriter) {
+			w.Write([]byte("ok"))
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusOK, true, 2},
+		{"informational", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusContinue)
+			w.WriteHeader(http.StatusAccepted)
+		}, http.StatusAccepted, true, 0},
+		{"flush", func(w http.ResponseWriter) { w.(http.Flusher).Flush() }, http.StatusOK, true, 0},
+	}
+
+	for _, test := range tests {
+		ww := WrapResponseWriter(httptest.NewRecorder())
+		test.handler(ww)
+
+		assert.Equal(t, test.status, ww.Status(), test.name)
+		assert.Equal(t, test.written, ww.Written(), test.name)
+		assert.Equal(t, test.bytes, ww.BytesWritten(), test.name)
+		if !test.written {
+			assert.Zero(t, ww.TimeToFirstByte(), test.name)
+		}
+	}
+}
+
+func TestWrapResponseWriterPush(t *testing.T) {
+	w := &hijackPushWriter{ResponseWriter: httptest.NewRecorder()}
+	ww := WrapResponseWriter(w)
+
+	assert.NoError(t, ww.(http.Pusher).Push("/app.js", nil))
+	assert.Equal(t, "/app.js", w.pushed)
+}
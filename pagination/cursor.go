@@ -0,0 +1,83 @@
+package pagination
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Cursor marks a position in a keyset paginated result set
+type Cursor struct {
+	// Values are the keyset column values of the row the page starts after
+	Values []interface{} `json:"v"`
+	// Backward is true when the page precedes the row, i.e. for previous page links
+	Backward bool `json:"b,omitempty"`
+}
+
+// EncodeCursor serializes and signs a cursor into an opaque url safe token
+func (c Config) EncodeCursor(cursor Cursor) (string, error) {
+	if len(c.Secret) == 0 {
+		return "", ErrNoSecret
+	}
+
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(c.sign(encoded)), nil
+}
+
+// DecodeCursor verifies and deserializes a token produced by EncodeCursor
+// Integer values are decoded as int64 and other numbers as float64.
+func (c Config) DecodeCursor(token string) (*Cursor, error) {
+	if len(c.Secret) == 0 {
+		return nil, ErrNoSecret
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, c.sign(parts[0])) {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var cursor Cursor
+	if err := decoder.Decode(&cursor); err != nil || len(cursor.Values) == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	for i, v := range cursor.Values {
+		if n, ok := v.(json.Number); ok {
+			if integer, err := n.Int64(); err == nil {
+				cursor.Values[i] = integer
+			} else if float, err := n.Float64(); err == nil {
+				cursor.Values[i] = float
+			}
+		}
+	}
+
+	return &cursor, nil
+}
+
+func (c Config) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
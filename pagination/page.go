@@ -0,0 +1,91 @@
+package pagination
+
+import (
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+)
+
+// KeysetPage describes the rows returned by a keyset paginated query
+type KeysetPage struct {
+	// HasMore is true when the query returned more rows than the page size,
+	// meaning there is another page in the direction of travel
+	HasMore bool
+	// FirstKey and LastKey are the keyset values of the first and last rows of the page, in display order
+	FirstKey []interface{}
+	LastKey  []interface{}
+}
+
+// OffsetPagination builds the pagination block of an offset paginated response, with links to the adjacent pages
+func (p *Params) OffsetPagination(r *http.Request, totalRecords int) *response.Pagination {
+	pagination := &response.Pagination{
+		Offset:       p.Offset,
+		Limit:        p.Limit,
+		TotalRecords: totalRecords,
+	}
+
+	if p.Offset+p.Limit < totalRecords {
+		pagination.Next = link(r, map[string]string{"offset": strconv.Itoa(p.Offset + p.Limit)})
+	}
+
+	if p.Offset > 0 {
+		prev := p.Offset - p.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		pagination.Prev = link(r, map[string]string{"offset": strconv.Itoa(prev)})
+	}
+
+	return pagination
+}
+
+// KeysetPagination builds the pagination block of a keyset paginated response, with cursors and links to the adjacent pages
+// TotalRecords is set to -1 as keyset pagination does not count rows.
+//
+// Return Values:
+//     1st: The pagination block
+//     2nd: An error representing failure to encode a cursor
+func (p *Params) KeysetPagination(r *http.Request, page KeysetPage) (*response.Pagination, error) {
+	pagination := &response.Pagination{Limit: p.Limit, TotalRecords: -1}
+
+	hasNext := page.HasMore
+	hasPrev := p.Cursor != nil
+	if p.Backward() {
+		// coming back from a later page, which is therefore always there
+		hasNext, hasPrev = true, page.HasMore
+	}
+
+	if hasNext && len(page.LastKey) > 0 {
+		cursor, err := p.config.EncodeCursor(Cursor{Values: page.LastKey})
+		if err != nil {
+			return nil, err
+		}
+		pagination.NextCursor = cursor
+		pagination.Next = link(r, map[string]string{"cursor": cursor})
+	}
+
+	if hasPrev && len(page.FirstKey) > 0 {
+		cursor, err := p.config.EncodeCursor(Cursor{Values: page.FirstKey, Backward: true})
+		if err != nil {
+			return nil, err
+		}
+		pagination.PrevCursor = cursor
+		pagination.Prev = link(r, map[string]string{"cursor": cursor})
+	}
+
+	return pagination, nil
+}
+
+// link returns the request path and query with the given parameters replaced
+func link(r *http.Request, params map[string]string) string {
+	query := r.URL.Query()
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	if _, ok := params["cursor"]; ok {
+		query.Del("offset")
+	}
+
+	return r.URL.Path + "?" + query.Encode()
+}
@@ -0,0 +1,187 @@
+// Package pagination parses paging parameters from requests, builds the matching
+// postgres clauses and fills the pagination block of a response.StandardResponse
+//
+// Two modes are supported:
+//   * offset pagination, through the `offset` and `limit` query parameters
+//   * keyset pagination, through the `cursor` and `limit` query parameters, where the
+//     cursor is an opaque signed token produced by a previous page
+package pagination
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+)
+
+const (
+	// DefaultLimit is the page size used when the request has no limit and none is configured
+	DefaultLimit = 20
+	// DefaultMaxLimit is the largest page size accepted when none is configured
+	DefaultMaxLimit = 100
+
+	// paramsKey is the context key holding the parsed Params
+	paramsKey = contextKey("params")
+)
+
+var (
+	ErrInvalidOffset   = errors.New("pagination: offset must be a non-negative integer")
+	ErrInvalidLimit    = errors.New("pagination: limit must be a positive integer")
+	ErrLimitTooLarge   = errors.New("pagination: limit exceeds the maximum")
+	ErrInvalidCursor   = errors.New("pagination: cursor is invalid")
+	ErrCursorAndOffset = errors.New("pagination: cursor and offset are mutually exclusive")
+	ErrNoSecret        = errors.New("pagination: cursors require a secret")
+)
+
+type contextKey string
+
+func (c contextKey) String() string {
+	return "pagination context key: " + string(c)
+}
+
+// Config configures paging for a route
+type Config struct {
+	// DefaultLimit is the page size used when the request has none, defaults to DefaultLimit
+	DefaultLimit int
+	// MaxLimit is the largest page size accepted, defaults to DefaultMaxLimit
+	MaxLimit int
+	// Secret signs keyset cursors, cursors are rejected when empty
+	Secret []byte
+}
+
+// Params are the validated paging parameters of a request
+type Params struct {
+	Offset int
+	Limit  int
+	// Cursor is the decoded keyset cursor, nil when the request uses offset pagination or asks for the first page
+	Cursor *Cursor
+
+	config Config
+}
+
+// Parse reads and validates the `offset`, `limit` and `cursor` query parameters of the request
+// The returned error is a ValidationErrors when the parameters are invalid.
+func (c Config) Parse(r *http.Request) (*Params, error) {
+	c = c.withDefaults()
+	query := r.URL.Query()
+
+	params := &Params{Limit: c.DefaultLimit, config: c}
+	var failures ValidationErrors
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		switch {
+		case err != nil || limit < 1:
+			failures = append(failures, Failure{"limit", "gte", v, "1", ErrInvalidLimit})
+		case limit > c.MaxLimit:
+			failures = append(failures, Failure{"limit", "lte", v, strconv.Itoa(c.MaxLimit), ErrLimitTooLarge})
+		default:
+			params.Limit = limit
+		}
+	}
+
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			failures = append(failures, Failure{"offset", "gte", v, "0", ErrInvalidOffset})
+		} else {
+			params.Offset = offset
+		}
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := c.DecodeCursor(v)
+		switch {
+		case err != nil:
+			failures = append(failures, Failure{"cursor", "cursor", v, "", err})
+		case query.Get("offset") != "":
+			failures = append(failures, Failure{"cursor", "excluded_with", v, "offset", ErrCursorAndOffset})
+		default:
+			params.Cursor = cursor
+		}
+	}
+
+	if len(failures) > 0 {
+		return nil, failures
+	}
+
+	return params, nil
+}
+
+// Middleware parses the paging parameters of every request with the given route configuration
+// Valid parameters are added to the request context, see FromContext,
+// invalid ones are answered with a 422 listing the validation failures, like invalid request bodies
+// are by the request package.
+func Middleware(config Config) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params, err := config.Parse(r)
+			if err != nil {
+				res := response.New()
+				res.StatusCode = http.StatusUnprocessableEntity
+				res.Message = "Invalid pagination parameters"
+				if failures, ok := err.(ValidationErrors); ok {
+					res.SetValidationFailures(failures.ValidationFailures())
+				}
+				response.Send(w, res)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), paramsKey, params)))
+		})
+	}
+}
+
+// FromContext returns the paging parameters added by Middleware, nil when there are none
+func FromContext(ctx context.Context) *Params {
+	params, _ := ctx.Value(paramsKey).(*Params)
+	return params
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxLimit < 1 {
+		c.MaxLimit = DefaultMaxLimit
+	}
+	if c.DefaultLimit < 1 {
+		c.DefaultLimit = DefaultLimit
+	}
+	if c.DefaultLimit > c.MaxLimit {
+		c.DefaultLimit = c.MaxLimit
+	}
+	return c
+}
+
+// ValidationErrors lists the invalid paging parameters of a request
+type ValidationErrors []Failure
+
+func (v ValidationErrors) Error() string {
+	if len(v) == 0 {
+		return ""
+	}
+	return v[0].err.Error()
+}
+
+// ValidationFailures converts the errors for use with response.StandardResponse.SetValidationFailures
+func (v ValidationErrors) ValidationFailures() []response.ValidationFailure {
+	failures := make([]response.ValidationFailure, len(v))
+	for i, f := range v {
+		failures[i] = f
+	}
+	return failures
+}
+
+// Failure describes an invalid paging parameter, it implements response.ValidationFailure
+type Failure struct {
+	field string
+	tag   string
+	value string
+	param string
+	err   error
+}
+
+func (f Failure) Field() string      { return f.field }
+func (f Failure) Tag() string        { return f.tag }
+func (f Failure) Value() interface{} { return f.value }
+func (f Failure) Param() string      { return f.param }
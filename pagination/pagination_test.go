@@ -0,0 +1,209 @@
+package pagination
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"github.com/stretchr/testify/assert"
+)
+
+var testConfig = Config{DefaultLimit: 10, MaxLimit: 50, Secret: []byte("secret")}
+
+func TestConfigParse(t *testing.T) {
+	cursor, _ := testConfig.EncodeCursor(Cursor{Values: []interface{}{"2019-06-01", 42}})
+
+	tests := []struct {
+		name       string
+		query      string
+		want       *Params
+		wantFields []string
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  &Params{Limit: 10},
+		},
+		{
+			name:  "offset and limit",
+			query: "offset=20&limit=50",
+			want:  &Params{Offset: 20, Limit: 50},
+		},
+		{
+			name:  "cursor",
+			query: "cursor=" + cursor,
+			want:  &Params{Limit: 10, Cursor: &Cursor{Values: []interface{}{"2019-06-01", int64(42)}}},
+		},
+		{
+			name:       "limit too large",
+			query:      "limit=51",
+			wantFields: []string{"limit"},
+		},
+		{
+			name:       "invalid values",
+			query:      "limit=0&offset=-1",
+			wantFields: []string{"limit", "offset"},
+		},
+		{
+			name:       "tampered cursor",
+			query:      "cursor=" + strings.Replace(cursor, "e", "f", 1),
+			wantFields: []string{"cursor"},
+		},
+		{
+			name:       "cursor with offset",
+			query:      "offset=10&cursor=" + cursor,
+			wantFields: []string{"cursor"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/items?"+tt.query, nil)
+			got, err := testConfig.Parse(r)
+
+			if tt.wantFields != nil {
+				failures, ok := err.(ValidationErrors)
+				if assert.True(t, ok, "returns validation errors") {
+					var fields []string
+					for _, f := range failures {
+						fields = append(fields, f.Field())
+					}
+					assert.Equal(t, tt.wantFields, fields)
+				}
+				return
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want.Offset, got.Offset, "offset")
+				assert.Equal(t, tt.want.Limit, got.Limit, "limit")
+				assert.Equal(t, tt.want.Cursor, got.Cursor, "cursor")
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRequiresSecret(t *testing.T) {
+	cursor, _ := testConfig.EncodeCursor(Cursor{Values: []interface{}{1}})
+
+	_, err := Config{}.DecodeCursor(cursor)
+	assert.Equal(t, ErrNoSecret, err)
+
+	_, err = Config{Secret: []byte("other")}.DecodeCursor(cursor)
+	assert.Equal(t, ErrInvalidCursor, err, "signed with another secret")
+}
+
+func TestMiddleware(t *testing.T) {
+	var params *Params
+	handler := Middleware(testConfig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = FromContext(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/items?limit=5", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.NotNil(t, params) {
+		assert.Equal(t, 5, params.Limit)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/items?limit=500", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var body response.StandardBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	if assert.NotNil(t, body.Validations) {
+		assert.Equal(t, "limit", (*body.Validations)[0].Field)
+		assert.Equal(t, "lte", (*body.Validations)[0].Tag)
+	}
+}
+
+func TestOffsetClause(t *testing.T) {
+	clause, args := (&Params{Offset: 40, Limit: 20}).OffsetClause(3)
+
+	assert.Equal(t, "LIMIT $3 OFFSET $4", clause)
+	assert.Equal(t, []interface{}{20, 40}, args)
+}
+
+func TestKeysetClause(t *testing.T) {
+	keyset := Keyset{Columns: []string{"created_at", "id"}, Descending: true}
+
+	where, orderBy, args, err := (&Params{Limit: 20}).KeysetClause(keyset, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "", where, "first page has no condition")
+	assert.Equal(t, "ORDER BY created_at DESC, id DESC LIMIT $1", orderBy)
+	assert.Equal(t, []interface{}{21}, args)
+
+	next := &Params{Limit: 20, Cursor: &Cursor{Values: []interface{}{"2019-06-01", int64(7)}}}
+	where, orderBy, args, err = next.KeysetClause(keyset, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "(created_at, id) < ($2, $3)", where)
+	assert.Equal(t, "ORDER BY created_at DESC, id DESC LIMIT $4", orderBy)
+	assert.Equal(t, []interface{}{"2019-06-01", int64(7), 21}, args)
+
+	prev := &Params{Limit: 20, Cursor: &Cursor{Values: []interface{}{"2019-06-01", int64(7)}, Backward: true}}
+	where, orderBy, _, err = prev.KeysetClause(keyset, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "(created_at, id) > ($1, $2)", where, "backward reverses the comparison")
+	assert.Equal(t, "ORDER BY created_at ASC, id ASC LIMIT $3", orderBy, "backward reverses the order")
+
+	_, _, _, err = next.KeysetClause(Keyset{Columns: []string{"id"}}, 1)
+	assert.Equal(t, ErrInvalidCursor, err, "cursor does not match the keyset")
+}
+
+func TestOffsetPagination(t *testing.T) {
+	r := httptest.NewRequest("GET", "/items?offset=10&limit=10&sort=name", nil)
+	params, _ := testConfig.Parse(r)
+
+	got := params.OffsetPagination(r, 25)
+
+	assert.Equal(t, 10, got.Offset)
+	assert.Equal(t, 25, got.TotalRecords)
+	assert.Equal(t, url.Values{"offset": {"20"}, "limit": {"10"}, "sort": {"name"}}, linkQuery(t, got.Next))
+	assert.Equal(t, url.Values{"offset": {"0"}, "limit": {"10"}, "sort": {"name"}}, linkQuery(t, got.Prev))
+
+	last := params.OffsetPagination(r, 20)
+	assert.Equal(t, "", last.Next, "no next page after the last record")
+}
+
+func TestKeysetPagination(t *testing.T) {
+	r := httptest.NewRequest("GET", "/items", nil)
+	params, _ := testConfig.Parse(r)
+
+	first, err := params.KeysetPagination(r, KeysetPage{HasMore: true, FirstKey: []interface{}{1}, LastKey: []interface{}{10}})
+	assert.NoError(t, err)
+	assert.Equal(t, "", first.Prev, "first page has no previous page")
+	if assert.NotEqual(t, "", first.Next) {
+		next, err := testConfig.DecodeCursor(linkQuery(t, first.Next).Get("cursor"))
+		assert.NoError(t, err)
+		assert.Equal(t, &Cursor{Values: []interface{}{int64(10)}}, next)
+		assert.Equal(t, first.NextCursor, linkQuery(t, first.Next).Get("cursor"))
+	}
+
+	// follow the next link: last page
+	r = httptest.NewRequest("GET", first.Next, nil)
+	params, _ = testConfig.Parse(r)
+	last, err := params.KeysetPagination(r, KeysetPage{HasMore: false, FirstKey: []interface{}{11}, LastKey: []interface{}{15}})
+	assert.NoError(t, err)
+	assert.Equal(t, "", last.Next, "last page has no next page")
+	if assert.NotEqual(t, "", last.Prev) {
+		prev, _ := testConfig.DecodeCursor(last.PrevCursor)
+		assert.Equal(t, &Cursor{Values: []interface{}{int64(11)}, Backward: true}, prev)
+	}
+
+	// follow the prev link: back to the first page
+	r = httptest.NewRequest("GET", last.Prev, nil)
+	params, _ = testConfig.Parse(r)
+	back, err := params.KeysetPagination(r, KeysetPage{HasMore: false, FirstKey: []interface{}{1}, LastKey: []interface{}{10}})
+	assert.NoError(t, err)
+	assert.Equal(t, "", back.Prev, "nothing before the first page")
+	assert.NotEqual(t, "", back.Next, "the page we came from")
+}
+
+func linkQuery(t *testing.T, link string) url.Values {
+	u, err := url.Parse(link)
+	assert.NoError(t, err)
+	return u.Query()
+}
@@ -0,0 +1,89 @@
+package pagination
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Keyset describes the columns a keyset paginated query is ordered by
+// The columns must uniquely identify a row, typically ending with the primary key,
+// and are written to the query as is, so they must never come from the request.
+type Keyset struct {
+	Columns    []string
+	Descending bool
+}
+
+// OffsetClause returns the LIMIT/OFFSET clause of an offset paginated query
+// Placeholders are numbered from argIndex, e.g. "LIMIT $3 OFFSET $4" for an argIndex of 3.
+//
+// Return Values:
+//     1st: The clause
+//     2nd: The arguments matching the placeholders
+func (p *Params) OffsetClause(argIndex int) (string, []interface{}) {
+	clause := "LIMIT " + placeholder(argIndex) + " OFFSET " + placeholder(argIndex+1)
+	return clause, []interface{}{p.Limit, p.Offset}
+}
+
+// KeysetClause returns the clauses of a keyset paginated query
+// Placeholders are numbered from argIndex. The limit is one more than the page size so that
+// the presence of another page can be detected, see KeysetPage.HasMore.
+// When the cursor points backward, the order is reversed and the rows must be reversed by the caller.
+//
+// Return Values:
+//     1st: The row condition, e.g. "(created_at, id) < ($1, $2)", empty on the first page
+//     2nd: The ORDER BY and LIMIT clauses
+//     3rd: The arguments matching the placeholders
+//     4th: ErrInvalidCursor when the cursor does not match the keyset
+func (p *Params) KeysetClause(keyset Keyset, argIndex int) (string, string, []interface{}, error) {
+	descending := keyset.Descending
+	var where string
+	var args []interface{}
+
+	if p.Cursor != nil {
+		if len(p.Cursor.Values) != len(keyset.Columns) {
+			return "", "", nil, ErrInvalidCursor
+		}
+
+		if p.Cursor.Backward {
+			descending = !descending
+		}
+
+		placeholders := make([]string, len(keyset.Columns))
+		for i := range keyset.Columns {
+			placeholders[i] = placeholder(argIndex)
+			argIndex++
+		}
+
+		operator := ">"
+		if descending {
+			operator = "<"
+		}
+
+		where = "(" + strings.Join(keyset.Columns, ", ") + ") " + operator + " (" + strings.Join(placeholders, ", ") + ")"
+		args = append(args, p.Cursor.Values...)
+	}
+
+	direction := " ASC"
+	if descending {
+		direction = " DESC"
+	}
+
+	order := make([]string, len(keyset.Columns))
+	for i, column := range keyset.Columns {
+		order[i] = column + direction
+	}
+
+	orderBy := "ORDER BY " + strings.Join(order, ", ") + " LIMIT " + placeholder(argIndex)
+	args = append(args, p.Limit+1)
+
+	return where, orderBy, args, nil
+}
+
+// Backward reports whether the rows of the page are fetched in reverse order
+func (p *Params) Backward() bool {
+	return p.Cursor != nil && p.Cursor.Backward
+}
+
+func placeholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
@@ -0,0 +1,300 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/go-chi/chi/middleware"
+	"github.com/jackc/pgx"
+	"github.com/rs/zerolog"
+)
+
+// QueryStats describes a single instrumented statement
+type QueryStats struct {
+	// Name identifies the statement without any of its values, e.g. "SELECT users"
+	Name      string
+	Operation string
+	Table     string
+	Duration  time.Duration
+	// RowsAffected is the number of rows changed by an exec, or read by a query
+	RowsAffected int64
+	Err          error
+}
+
+// QueryObserver is called once for every instrumented statement
+type QueryObserver func(ctx context.Context, stats QueryStats)
+
+// InstrumentConfig configures the instrumentation of the DB query helpers
+type InstrumentConfig struct {
+	// Logger receives slow query logs, nothing is logged when nil
+	// Statements of a request are logged with its request logger instead, see utils.LoggerFromContext.
+	Logger *zerolog.Logger
+	// SlowThreshold is the duration above which a statement is logged as slow, zero disables slow query logs
+	SlowThreshold time.Duration
+	// Observer is notified of every statement, for example to feed metrics
+	Observer QueryObserver
+}
+
+var (
+	// statementNamePattern matches a leading `-- name: GetUser` or `/* name: GetUser */` annotation
+	statementNamePattern = regexp.MustCompile(`^\s*(?:--|/\*)\s*name:\s*([\w.-]+)`)
+	// statementTablePattern finds the table a statement operates on
+	statementTablePattern = regexp.MustCompile(`(?i)\b(?:from|into|update|join)\s+((?:"[^"]+"|[\w]+)(?:\.(?:"[^"]+"|[\w]+))?)`)
+	// statementCommentPattern strips comments before looking for the operation
+	statementCommentPattern = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+	// statementLiteralPattern finds the string and numeric literals of a statement, along with
+	// quoted identifiers and placeholders so that these are left untouched
+	statementLiteralPattern = regexp.MustCompile(`"(?:[^"]|"")*"|\$\d+|'(?:[^']|'')*'|\b\d+(?:\.\d+)?\b`)
+)
+
+// Instrument enables recording of duration, rows affected and errors for statements
+// issued through Exec, Query, QueryRow, ReadQuery and ReadQueryRow
+// Statements are attached as datastore segments to the APM transaction found in the
+// context, as put there by middleware.AddTracing, see apm.FromContext.
+func (db *DB) Instrument(config InstrumentConfig) {
+	db.instrument = config
+}
+
+// Exec executes sql with the given arguments, recording it
+// Statements are always sent to the primary.
+func (db *DB) Exec(ctx context.Context, sql string, args ...interface{}) (pgx.CommandTag, error) {
+	pool := db.Writer(ctx)
+	end := db.startStatement(ctx, sql, pool, db.host, db.port)
+
+	tag, err := pool.ExecEx(ctx, sql, nil, args...)
+	end(tag.RowsAffected(), err)
+
+	return tag, err
+}
+
+// Query executes sql with the given arguments, the statement is recorded once the returned rows are closed
+// Statements are always sent to the primary, see ReadQuery to read from a replica.
+func (db *DB) Query(ctx context.Context, sql string, args ...interface{}) (*Rows, error) {
+	return db.query(ctx, db.Writer(ctx), db.host, db.port, sql, args...)
+}
+
+// QueryRow executes sql with the given arguments, the statement is recorded once the row is scanned
+// Statements are always sent to the primary, see ReadQueryRow to read from a replica.
+func (db *DB) QueryRow(ctx context.Context, sql string, args ...interface{}) *Row {
+	rows, err := db.Query(ctx, sql, args...)
+	return &Row{rows: rows, err: err}
+}
+
+// ReadQuery works the same as Query, but sends the statement to a replica when the DB has a healthy one
+// It is meant for reads which tolerate replication lag and have no side effect, selects taking row locks
+// and reads of a request flagged to use the primary (see ReadYourWrites and WithPrimary) still go to the primary.
+func (db *DB) ReadQuery(ctx context.Context, sql string, args ...interface{}) (*Rows, error) {
+	if operation, _, _ := StatementName(sql); !isReadOnlyStatement(operation, sql) {
+		return db.Query(ctx, sql, args...)
+	}
+
+	if r := db.reader(ctx); r != nil {
+		return db.query(ctx, r.connPool(), r.host, r.port, sql, args...)
+	}
+	return db.query(ctx, db.Pool, db.host, db.port, sql, args...)
+}
+
+// ReadQueryRow works the same as QueryRow, but sends the statement to a replica like ReadQuery
+func (db *DB) ReadQueryRow(ctx context.Context, sql string, args ...interface{}) *Row {
+	rows, err := db.ReadQuery(ctx, sql, args...)
+	return &Row{rows: rows, err: err}
+}
+
+// query executes sql on pool, recording it against host and port
+func (db *DB) query(ctx context.Context, pool *pgx.ConnPool, host string, port string, sql string, args ...interface{}) (*Rows, error) {
+	end := db.startStatement(ctx, sql, pool, host, port)
+
+	rows, err := pool.QueryEx(ctx, sql, nil, args...)
+	if err != nil {
+		end(0, err)
+		return nil, err
+	}
+
+	return &Rows{Rows: rows, end: end}, nil
+}
+
+// Stats returns the current connection pool statistics of the primary
+func (db *DB) Stats() pgx.ConnPoolStat {
+	return db.Pool.Stat()
+}
+
+// ReplicaStats returns the current connection pool statistics of each replica
+func (db *DB) ReplicaStats() []pgx.ConnPoolStat {
+	if db.replicas == nil {
+		return nil
+	}
+
+	stats := make([]pgx.ConnPoolStat, len(db.replicas.replicas))
+	for i, r := range db.replicas.replicas {
+		stats[i] = r.stat()
+	}
+	return stats
+}
+
+// Rows wraps pgx.Rows to record the statement when the result set is exhausted or closed
+type Rows struct {
+	*pgx.Rows
+
+	rowCount int64
+	end      func(rowsAffected int64, err error)
+}
+
+// Next prepares the next row for reading, see pgx.Rows.Next
+func (r *Rows) Next() bool {
+	if r.Rows.Next() {
+		r.rowCount++
+		return true
+	}
+	r.finish()
+	return false
+}
+
+// Close closes the rows, see pgx.Rows.Close
+func (r *Rows) Close() {
+	r.Rows.Close()
+	r.finish()
+}
+
+func (r *Rows) finish() {
+	if r.end != nil {
+		r.end(r.rowCount, r.Rows.Err())
+		r.end = nil
+	}
+}
+
+// Row is the result of QueryRow
+type Row struct {
+	rows *Rows
+	err  error
+}
+
+// Scan works the same as pgx.Row.Scan, returning pgx.ErrNoRows when nothing was found
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return pgx.ErrNoRows
+	}
+
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+	r.rows.Close()
+
+	return r.rows.Err()
+}
+
+// startStatement begins recording a statement run on pool, the returned function completes it
+func (db *DB) startStatement(ctx context.Context, sql string, pool *pgx.ConnPool, host string, port string) func(rowsAffected int64, err error) {
+	operation, table, name := StatementName(sql)
+
+	segment := apm.FromContext(ctx).StartDatastoreSegment(apm.DatastoreSegment{
+		Product:    apm.ProductPostgres,
+		Collection: table,
+		Operation:  operation,
+		Query:      SanitizeStatement(sql),
+		Host:       host,
+		Port:       port,
+		Database:   db.databaseName,
+	})
+
+	start := time.Now()
+
+	return func(rowsAffected int64, err error) {
+		segment.End()
+
+		stats := QueryStats{
+			Name:         name,
+			Operation:    operation,
+			Table:        table,
+			Duration:     time.Since(start),
+			RowsAffected: rowsAffected,
+			Err:          err,
+		}
+
+		if db.instrument.Observer != nil {
+			db.instrument.Observer(ctx, stats)
+		}
+
+		if db.instrument.Logger != nil && db.instrument.SlowThreshold > 0 && stats.Duration >= db.instrument.SlowThreshold {
+			db.logSlowStatement(ctx, stats, host, pool.Stat())
+		}
+	}
+}
+
+// logSlowStatement logs a slow statement along with the statistics of the pool it ran on
+func (db *DB) logSlowStatement(ctx context.Context, stats QueryStats, host string, pool pgx.ConnPoolStat) {
+	// the request logger already carries the request id
+	logger, ok := utils.LoggerFromContext(ctx)
+	if !ok {
+		withRID := db.instrument.Logger.With().Str("rid", middleware.GetReqID(ctx)).Logger()
+		logger = &withRID
+	}
+
+	log := logger.Warn().Timestamp().
+		Str("statement", stats.Name).
+		Str("host", host).
+		Int64("dur_ms", stats.Duration.Nanoseconds()/1000000).
+		Int64("rows", stats.RowsAffected).
+		Int("pool_in_use", pool.CheckedOutConnections()).
+		Int("pool_max", pool.MaxConnections)
+
+	if stats.Err != nil {
+		log = log.Err(stats.Err)
+	}
+
+	log.Msg("slow_query")
+}
+
+// StatementName describes a statement without any of its values
+// A leading `-- name: GetUser` annotation is used as the name when present,
+// otherwise the name is built from the operation and the table, e.g. "SELECT users".
+//
+// Return Values:
+//     1st: The operation, e.g. "SELECT"
+//     2nd: The table the statement operates on, empty when it cannot be determined
+//     3rd: The statement name
+func StatementName(sql string) (operation string, table string, name string) {
+	stripped := strings.TrimSpace(statementCommentPattern.ReplaceAllString(sql, " "))
+
+	if fields := strings.Fields(stripped); len(fields) > 0 {
+		operation = strings.ToUpper(strings.TrimRight(fields[0], "(;"))
+	}
+
+	if match := statementTablePattern.FindStringSubmatch(stripped); match != nil {
+		table = strings.Replace(match[1], `"`, "", -1)
+	}
+
+	if match := statementNamePattern.FindStringSubmatch(sql); match != nil {
+		return operation, table, match[1]
+	}
+
+	name = operation
+	if table != "" {
+		name += " " + table
+	}
+
+	return operation, table, name
+}
+
+// SanitizeStatement replaces the string and numeric literals of sql with `?` so that the statement
+// can be reported without any of its values, e.g. "UPDATE users SET name = ? WHERE id = $1"
+// Placeholders and quoted identifiers are kept.
+func SanitizeStatement(sql string) string {
+	return statementLiteralPattern.ReplaceAllStringFunc(sql, func(match string) string {
+		if match[0] == '"' || match[0] == '$' {
+			return match
+		}
+		return "?"
+	})
+}
@@ -0,0 +1,131 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementName(t *testing.T) {
+	tests := []struct {
+		name          string
+		sql           string
+		wantOperation string
+		wantTable     string
+		wantName      string
+	}{
+		{
+			name:          "select",
+			sql:           "SELECT id, name FROM users WHERE id = $1",
+			wantOperation: "SELECT",
+			wantTable:     "users",
+			wantName:      "SELECT users",
+		},
+		{
+			name:          "insert with schema",
+			sql:           `insert into "planning"."events" (id) values ($1)`,
+			wantOperation: "INSERT",
+			wantTable:     "planning.events",
+			wantName:      "INSERT planning.events",
+		},
+		{
+			name:          "update",
+			sql:           "\n\tUPDATE users SET name = 'secret' WHERE id = 4",
+			wantOperation: "UPDATE",
+			wantTable:     "users",
+			wantName:      "UPDATE users",
+		},
+		{
+			name:          "delete",
+			sql:           "DELETE FROM sessions WHERE expires_at < now()",
+			wantOperation: "DELETE",
+			wantTable:     "sessions",
+			wantName:      "DELETE sessions",
+		},
+		{
+			name:          "annotated",
+			sql:           "-- name: GetUserByEmail\nSELECT * FROM users WHERE email = $1",
+			wantOperation: "SELECT",
+			wantTable:     "users",
+			wantName:      "GetUserByEmail",
+		},
+		{
+			name:          "no table",
+			sql:           "SELECT 1",
+			wantOperation: "SELECT",
+			wantTable:     "",
+			wantName:      "SELECT",
+		},
+		{
+			name:          "empty",
+			sql:           "",
+			wantOperation: "",
+			wantTable:     "",
+			wantName:      "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation, table, name := StatementName(tt.sql)
+			assert.Equal(t, tt.wantOperation, operation, "operation")
+			assert.Equal(t, tt.wantTable, table, "table")
+			assert.Equal(t, tt.wantName, name, "name")
+		})
+	}
+}
+
+func TestSanitizeStatement(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			name: "placeholders",
+			sql:  "SELECT id, name FROM users WHERE id = $1",
+			want: "SELECT id, name FROM users WHERE id = $1",
+		},
+		{
+			name: "string and number",
+			sql:  "UPDATE users SET name = 'secret' WHERE id = 4",
+			want: "UPDATE users SET name = ? WHERE id = ?",
+		},
+		{
+			name: "escaped quote",
+			sql:  "SELECT * FROM users WHERE email = 'o''brien@example.com' AND score > 1.5",
+			want: "SELECT * FROM users WHERE email = ? AND score > ?",
+		},
+		{
+			name: "identifiers",
+			sql:  `INSERT INTO "t2"."2fa" (code2) VALUES ('123456', $2)`,
+			want: `INSERT INTO "t2"."2fa" (code2) VALUES (?, $2)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SanitizeStatement(tt.sql))
+		})
+	}
+}
+
+func TestLogSlowStatement(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	db := &DB{instrument: InstrumentConfig{Logger: &logger, SlowThreshold: time.Millisecond}}
+
+	replicaStat := pgx.ConnPoolStat{MaxConnections: 10, CurrentConnections: 4, AvailableConnections: 1}
+	db.logSlowStatement(context.Background(), QueryStats{Name: "SELECT users", Duration: time.Second}, "replica", replicaStat)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "slow_query", entry["message"])
+	assert.Equal(t, "replica", entry["host"], "host that ran the statement")
+	assert.Equal(t, float64(3), entry["pool_in_use"], "in use connections of that pool")
+	assert.Equal(t, float64(10), entry["pool_max"])
+}
@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"strconv"
+
+	"github.com/jackc/pgx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolMaxDesc = prometheus.NewDesc("postgres_pool_max_connections",
+		"Maximum number of connections of the pool.", []string{"pool"}, nil)
+	poolOpenDesc = prometheus.NewDesc("postgres_pool_open_connections",
+		"Number of open connections of the pool.", []string{"pool"}, nil)
+	poolInUseDesc = prometheus.NewDesc("postgres_pool_in_use_connections",
+		"Number of connections of the pool currently checked out.", []string{"pool"}, nil)
+)
+
+// Collector returns a Prometheus collector of the connection pool statistics,
+// labeled "primary" for the primary pool and "replica_<n>" for each replica
+func (db *DB) Collector() prometheus.Collector {
+	return poolCollector{db: db}
+}
+
+type poolCollector struct {
+	db *DB
+}
+
+// Describe implements prometheus.Collector
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolMaxDesc
+	ch <- poolOpenDesc
+	ch <- poolInUseDesc
+}
+
+// Collect implements prometheus.Collector
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	collectPool(ch, "primary", c.db.Stats())
+	for i, stat := range c.db.ReplicaStats() {
+		collectPool(ch, "replica_"+strconv.Itoa(i), stat)
+	}
+}
+
+func collectPool(ch chan<- prometheus.Metric, pool string, stat pgx.ConnPoolStat) {
+	ch <- prometheus.MustNewConstMetric(poolMaxDesc, prometheus.GaugeValue, float64(stat.MaxConnections), pool)
+	ch <- prometheus.MustNewConstMetric(poolOpenDesc, prometheus.GaugeValue, float64(stat.CurrentConnections), pool)
+	ch <- prometheus.MustNewConstMetric(poolInUseDesc, prometheus.GaugeValue, float64(stat.CheckedOutConnections()), pool)
+}
@@ -0,0 +1,475 @@
+// Package migrate applies versioned up/down SQL migrations to a postgres database
+//
+// Migrations are plain SQL files named `<version>_<name>.up.sql` and `<version>_<name>.down.sql`,
+// usually embedded in the service binary and applied from main before serving:
+//
+//     //go:embed migrations/*.sql
+//     var migrations embed.FS
+//
+//     m, err := migrate.New(db, migrations, migrate.Config{Dir: "migrations"}, &logger)
+//     if err != nil {
+//         logger.Fatal().Err(err).Msg("unable to load migrations")
+//     }
+//     if err := m.Run(ctx, []string{"up"}); err != nil {
+//         logger.Fatal().Err(err).Msg("unable to migrate")
+//     }
+//
+// Applied versions and their checksums are recorded in a schema table, and every run applying or
+// rolling back migrations holds a postgres advisory lock so that replicas starting at the same time
+// do not race each other.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/postgres"
+	"github.com/jackc/pgx"
+	"github.com/rs/zerolog"
+)
+
+// DefaultTable is the table used to record applied migrations when none is configured
+const DefaultTable = "schema_migrations"
+
+var (
+	// ErrMisconfigured is returned by New when the database, source or logger is missing
+	ErrMisconfigured = errors.New("migrate: misconfigured")
+	// ErrChecksumMismatch is returned when the file of an applied migration changed since it was applied
+	ErrChecksumMismatch = errors.New("migrate: applied migration has been modified")
+	// ErrUnknownVersion is returned when an applied version has no migration file anymore
+	ErrUnknownVersion = errors.New("migrate: applied version has no migration file")
+	// ErrNoDownMigration is returned when rolling back a migration without a down file
+	ErrNoDownMigration = errors.New("migrate: migration has no down file")
+	// ErrInvalidCommand is returned by Run for unknown commands or invalid arguments
+	ErrInvalidCommand = errors.New("migrate: invalid command")
+	// ErrVersionNotFound is returned by UpTo when no migration has the target version
+	ErrVersionNotFound = errors.New("migrate: target version not found")
+	// ErrInvalidTargetSteps is returned by Down when the number of steps is not positive
+	ErrInvalidTargetSteps = errors.New("migrate: steps must be positive")
+)
+
+// Config configures a Migrator
+type Config struct {
+	// Dir is the directory of the file system holding the migration files
+	Dir string
+	// Table is the (optionally schema qualified) table recording applied migrations, defaults to DefaultTable
+	Table string
+	// LockID is the advisory lock key, derived from Table when zero
+	LockID int64
+	// DryRun logs the migrations that would run without executing them
+	// Dry runs neither take the advisory lock nor create the schema table.
+	DryRun bool
+}
+
+// Status describes the state of a single migration version
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is true when the source file changed after the migration was applied
+	Modified bool
+	// Missing is true when the version was applied but its source file no longer exists
+	Missing bool
+}
+
+// Migrator applies migrations loaded from a file system to a database
+type Migrator struct {
+	db         *postgres.DB
+	migrations []Migration
+	table      pgx.Identifier
+	lockID     int64
+	dryRun     bool
+	logger     *zerolog.Logger
+}
+
+// appliedMigration is a row of the schema table
+type appliedMigration struct {
+	version   int64
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// New creates a Migrator with the migrations found in the given file system
+func New(db *postgres.DB, source fs.FS, config Config, logger *zerolog.Logger) (*Migrator, error) {
+	if db == nil || source == nil || logger == nil {
+		return nil, ErrMisconfigured
+	}
+
+	migrations, err := Load(source, config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	table := config.Table
+	if table == "" {
+		table = DefaultTable
+	}
+
+	lockID := config.LockID
+	if lockID == 0 {
+		lockID = defaultLockID(table)
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		table:      pgx.Identifier(strings.Split(table, ".")),
+		lockID:     lockID,
+		dryRun:     config.DryRun,
+		logger:     logger,
+	}, nil
+}
+
+// Run executes a migration command, as typically received from the command line
+//   up [version]  applies pending migrations, up to and including version when given
+//   down [steps]  rolls back the given number of applied migrations, one by default
+//   status        logs the state of every migration
+func (m *Migrator) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return ErrInvalidCommand
+	}
+
+	var arg int64
+	if len(args) == 2 {
+		var err error
+		if arg, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+		}
+	}
+
+	switch args[0] {
+	case "up":
+		_, err := m.UpTo(ctx, arg)
+		return err
+	case "down":
+		if len(args) == 1 {
+			arg = 1
+		}
+		_, err := m.Down(ctx, int(arg))
+		return err
+	case "status":
+		if len(args) != 1 {
+			return ErrInvalidCommand
+		}
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			m.logger.Info().Int64("version", s.Version).Str("name", s.Name).Bool("applied", s.Applied).
+				Time("applied_at", s.AppliedAt).Bool("modified", s.Modified).Bool("missing", s.Missing).Msg("migration status")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrInvalidCommand, args[0])
+}
+
+// Up applies all pending migrations
+//
+// Return Values:
+//     1st: The migrations applied, or that would be applied on a dry run
+//     2nd: An error representing failure to apply a migration
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo applies pending migrations up to and including the given version, zero meaning all of them
+//
+// Return Values:
+//     1st: The migrations applied, or that would be applied on a dry run
+//     2nd: An error representing failure to apply a migration
+func (m *Migrator) UpTo(ctx context.Context, version int64) ([]Migration, error) {
+	var done []Migration
+
+	err := m.withConn(ctx, m.dryRun, func(conn *pgx.Conn) error {
+		applied, err := m.loadApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		plan, err := planUp(m.migrations, applied, version)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range plan {
+			if !m.dryRun {
+				if err := m.apply(ctx, conn, migration.Up, m.recordSQL(), migration.Version, migration.Name, migration.Checksum()); err != nil {
+					return fmt.Errorf("unable to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+				}
+			}
+			m.logger.Info().Int64("version", migration.Version).Str("name", migration.Name).Bool("dry_run", m.dryRun).Msg("migration applied")
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the given number of most recently applied migrations
+//
+// Return Values:
+//     1st: The migrations rolled back, or that would be rolled back on a dry run
+//     2nd: An error representing failure to roll back a migration
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, ErrInvalidTargetSteps
+	}
+
+	var done []Migration
+
+	err := m.withConn(ctx, m.dryRun, func(conn *pgx.Conn) error {
+		applied, err := m.loadApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		plan, err := planDown(m.migrations, applied, steps)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range plan {
+			if !m.dryRun {
+				if err := m.apply(ctx, conn, migration.Down, m.forgetSQL(), migration.Version); err != nil {
+					return fmt.Errorf("unable to roll back migration %d_%s: %w", migration.Version, migration.Name, err)
+				}
+			}
+			m.logger.Info().Int64("version", migration.Version).Str("name", migration.Name).Bool("dry_run", m.dryRun).Msg("migration rolled back")
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Status reports every known migration, whether it was applied and whether it drifted from its source
+// It neither takes the advisory lock nor creates the schema table, nothing is applied when it is missing.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.withConn(ctx, true, func(conn *pgx.Conn) error {
+		applied, err := m.loadApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		statuses = buildStatus(m.migrations, applied)
+		return nil
+	})
+
+	return statuses, err
+}
+
+// withConn runs fn on a dedicated connection
+// Unless readOnly, the connection holds the migration advisory lock and the schema table is created first.
+func (m *Migrator) withConn(ctx context.Context, readOnly bool, fn func(conn *pgx.Conn) error) error {
+	conn, err := m.db.Pool.Acquire()
+	if err != nil {
+		return err
+	}
+	defer m.db.Pool.Release(conn)
+
+	if readOnly {
+		return fn(conn)
+	}
+
+	if _, err := conn.ExecEx(ctx, "SELECT pg_advisory_lock($1)", nil, m.lockID); err != nil {
+		return fmt.Errorf("unable to acquire migration lock: %w", err)
+	}
+	defer func() {
+		// the lock is released with the session anyway, so a failure here is only worth a log
+		if _, err := conn.ExecEx(context.Background(), "SELECT pg_advisory_unlock($1)", nil, m.lockID); err != nil {
+			m.logger.Error().Err(err).Msg("unable to release migration lock")
+		}
+	}()
+
+	createSQL := "CREATE TABLE IF NOT EXISTS " + m.table.Sanitize() + ` (
+		version    bigint PRIMARY KEY,
+		name       text NOT NULL,
+		checksum   text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`
+	if _, err := conn.ExecEx(ctx, createSQL, nil); err != nil {
+		return fmt.Errorf("unable to create migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// loadApplied returns the applied migrations by version, none when the schema table does not exist
+func (m *Migrator) loadApplied(ctx context.Context, conn *pgx.Conn) (map[int64]appliedMigration, error) {
+	applied := map[int64]appliedMigration{}
+
+	var exists bool
+	if err := conn.QueryRowEx(ctx, "SELECT to_regclass($1) IS NOT NULL", nil, m.table.Sanitize()).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := conn.QueryEx(ctx, "SELECT version, name, checksum, applied_at FROM "+m.table.Sanitize(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[a.version] = a
+	}
+
+	return applied, rows.Err()
+}
+
+// apply runs a migration script and its bookkeeping statement in a single transaction
+func (m *Migrator) apply(ctx context.Context, conn *pgx.Conn, script string, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackEx(ctx)
+
+	// the simple protocol allows scripts with several statements
+	if _, err := tx.ExecEx(ctx, script, &pgx.QueryExOptions{SimpleProtocol: true}); err != nil {
+		return err
+	}
+	if _, err := tx.ExecEx(ctx, bookkeeping, nil, args...); err != nil {
+		return err
+	}
+
+	return tx.CommitEx(ctx)
+}
+
+func (m *Migrator) recordSQL() string {
+	return "INSERT INTO " + m.table.Sanitize() + " (version, name, checksum) VALUES ($1, $2, $3)"
+}
+
+func (m *Migrator) forgetSQL() string {
+	return "DELETE FROM " + m.table.Sanitize() + " WHERE version = $1"
+}
+
+// planUp returns the pending migrations up to target (zero for all), after verifying
+// that applied migrations were not modified or removed
+func planUp(migrations []Migration, applied map[int64]appliedMigration, target int64) ([]Migration, error) {
+	if err := verify(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	if target != 0 && !hasVersion(migrations, target) {
+		return nil, fmt.Errorf("%w: %d", ErrVersionNotFound, target)
+	}
+
+	var plan []Migration
+	for _, migration := range migrations {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			plan = append(plan, migration)
+		}
+	}
+
+	return plan, nil
+}
+
+// planDown returns the most recently applied migrations to roll back, newest first
+func planDown(migrations []Migration, applied map[int64]appliedMigration, steps int) ([]Migration, error) {
+	if err := verify(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	var plan []Migration
+	for i := len(migrations) - 1; i >= 0 && len(plan) < steps; i-- {
+		migration := migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == "" {
+			return nil, fmt.Errorf("%w: %d_%s", ErrNoDownMigration, migration.Version, migration.Name)
+		}
+		plan = append(plan, migration)
+	}
+
+	return plan, nil
+}
+
+func verify(migrations []Migration, applied map[int64]appliedMigration) error {
+	known := make(map[int64]Migration, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, a := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("%w: %d_%s", ErrUnknownVersion, version, a.name)
+		}
+		if migration.Checksum() != a.checksum {
+			return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, version, migration.Name)
+		}
+	}
+
+	return nil
+}
+
+func buildStatus(migrations []Migration, applied map[int64]appliedMigration) []Status {
+	statuses := make([]Status, 0, len(migrations))
+	seen := make(map[int64]struct{}, len(migrations))
+
+	for _, migration := range migrations {
+		seen[migration.Version] = struct{}{}
+		s := Status{Version: migration.Version, Name: migration.Name}
+		if a, ok := applied[migration.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.appliedAt
+			s.Modified = a.checksum != migration.Checksum()
+		}
+		statuses = append(statuses, s)
+	}
+
+	for version, a := range applied {
+		if _, ok := seen[version]; !ok {
+			statuses = append(statuses, Status{Version: version, Name: a.name, Applied: true, AppliedAt: a.appliedAt, Missing: true})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses
+}
+
+func hasVersion(migrations []Migration, version int64) bool {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultLockID derives a stable advisory lock key from the migrations table name
+func defaultLockID(table string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("go-svc-bootstrap/migrate:" + table))
+	return int64(h.Sum64())
+}
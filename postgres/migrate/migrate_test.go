@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSource() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD email text;")},
+		"migrations/0002_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP email;")},
+		"migrations/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id bigint);")},
+		"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"migrations/0003_backfill.up.sql":       {Data: []byte("UPDATE users SET email = '';")},
+		"migrations/README.md":                  {Data: []byte("ignored")},
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testSource(), "migrations")
+	assert.NoError(t, err)
+
+	if assert.Len(t, migrations, 3) {
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Equal(t, "create_users", migrations[0].Name)
+		assert.Equal(t, "DROP TABLE users;", migrations[0].Down)
+		assert.Equal(t, int64(2), migrations[1].Version)
+		assert.Equal(t, int64(3), migrations[2].Version)
+		assert.Equal(t, "", migrations[2].Down, "down file is optional")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source fstest.MapFS
+	}{
+		{
+			name:   "missing directory",
+			source: fstest.MapFS{},
+		},
+		{
+			name: "down without up",
+			source: fstest.MapFS{
+				"migrations/0001_users.down.sql": {Data: []byte("DROP TABLE users;")},
+			},
+		},
+		{
+			name: "conflicting names",
+			source: fstest.MapFS{
+				"migrations/0001_users.up.sql":    {Data: []byte("CREATE TABLE users ();")},
+				"migrations/0001_accounts.up.sql": {Data: []byte("CREATE TABLE accounts ();")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.source, "migrations")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPlanUp(t *testing.T) {
+	migrations, _ := Load(testSource(), "migrations")
+	applied := map[int64]appliedMigration{
+		1: {version: 1, name: "create_users", checksum: migrations[0].Checksum()},
+	}
+
+	plan, err := planUp(migrations, applied, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, versions(plan), "applies every pending migration")
+
+	plan, err = planUp(migrations, applied, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2}, versions(plan), "stops at the target version")
+
+	_, err = planUp(migrations, applied, 9)
+	assert.True(t, errors.Is(err, ErrVersionNotFound), "unknown target version")
+
+	applied[1] = appliedMigration{version: 1, name: "create_users", checksum: "changed"}
+	_, err = planUp(migrations, applied, 0)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "modified migration")
+
+	applied = map[int64]appliedMigration{7: {version: 7, name: "gone"}}
+	_, err = planUp(migrations, applied, 0)
+	assert.True(t, errors.Is(err, ErrUnknownVersion), "applied migration without file")
+}
+
+func TestPlanDown(t *testing.T) {
+	migrations, _ := Load(testSource(), "migrations")
+	applied := map[int64]appliedMigration{
+		1: {version: 1, checksum: migrations[0].Checksum()},
+		2: {version: 2, checksum: migrations[1].Checksum()},
+	}
+
+	plan, err := planDown(migrations, applied, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2}, versions(plan), "rolls back the latest migration")
+
+	plan, err = planDown(migrations, applied, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 1}, versions(plan), "rolls back newest first")
+
+	applied[3] = appliedMigration{version: 3, checksum: migrations[2].Checksum()}
+	_, err = planDown(migrations, applied, 1)
+	assert.True(t, errors.Is(err, ErrNoDownMigration), "migration without down file")
+}
+
+func TestBuildStatus(t *testing.T) {
+	migrations, _ := Load(testSource(), "migrations")
+	appliedAt := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	applied := map[int64]appliedMigration{
+		1: {version: 1, name: "create_users", checksum: migrations[0].Checksum(), appliedAt: appliedAt},
+		2: {version: 2, name: "add_email", checksum: "changed", appliedAt: appliedAt},
+		9: {version: 9, name: "gone", appliedAt: appliedAt},
+	}
+
+	assert.Equal(t, []Status{
+		{Version: 1, Name: "create_users", Applied: true, AppliedAt: appliedAt},
+		{Version: 2, Name: "add_email", Applied: true, AppliedAt: appliedAt, Modified: true},
+		{Version: 3, Name: "backfill"},
+		{Version: 9, Name: "gone", Applied: true, AppliedAt: appliedAt, Missing: true},
+	}, buildStatus(migrations, applied))
+}
+
+func TestDefaultLockID(t *testing.T) {
+	assert.Equal(t, defaultLockID(DefaultTable), defaultLockID(DefaultTable), "lock id is stable")
+	assert.NotEqual(t, defaultLockID(DefaultTable), defaultLockID("other.migrations"), "lock id depends on the table")
+}
+
+func versions(migrations []Migration) []int64 {
+	var v []int64
+	for _, m := range migrations {
+		v = append(v, m.Version)
+	}
+	return v
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/jackc/pgx"
 )
@@ -9,6 +10,12 @@ import (
 // DB is a configured instance of a postgres database
 type DB struct {
 	Pool *pgx.ConnPool
+
+	host         string
+	port         string
+	databaseName string
+	instrument   InstrumentConfig
+	replicas     *replicaSet
 }
 
 // Connect creates the initial context for operating with the PostgresDB
@@ -21,7 +28,12 @@ func Connect(config *pgx.ConnPoolConfig) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{pool}, nil
+	return &DB{
+		Pool:         pool,
+		host:         config.Host,
+		port:         portString(config.Port),
+		databaseName: config.Database,
+	}, nil
 }
 
 // Ping verifies a connection to the database is still alive, establishing a connection if necessary
@@ -29,13 +41,7 @@ func Connect(config *pgx.ConnPoolConfig) (*DB, error) {
 // Return Values:
 //     1st: An error representing failure to connect
 func (db *DB) Ping() error {
-	conn, err := db.Pool.Acquire()
-	if err != nil {
-		return err
-	}
-	defer db.Pool.Release(conn)
-
-	return conn.Ping(context.Background())
+	return ping(context.Background(), db.Pool)
 }
 
 // Close closes the database, releasing any open resources
@@ -44,5 +50,16 @@ func (db *DB) Ping() error {
 // Return Values:
 //     1st: An error representing failure to close the connection
 func (db *DB) Close() {
+	if db.replicas != nil {
+		db.replicas.close()
+	}
 	db.Pool.Close()
 }
+
+// portString formats the configured port, accounting for the pgx default
+func portString(port uint16) string {
+	if port == 0 {
+		port = 5432
+	}
+	return strconv.Itoa(int(port))
+}
@@ -0,0 +1,323 @@
+package postgres
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/rs/zerolog"
+)
+
+// Selection is the strategy used to pick a replica for reads
+type Selection int
+
+const (
+	// RoundRobin spreads reads evenly across healthy replicas
+	RoundRobin Selection = iota
+	// LeastConnections sends reads to the healthy replica with the fewest connections in use
+	LeastConnections
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+
+	// primaryHintKey is the context key holding the read-your-writes hint
+	primaryHintKey = contextKey("primaryHint")
+)
+
+// contextKey is the type of the postgres context keys
+type contextKey string
+
+func (c contextKey) String() string {
+	return "postgres context key: " + string(c)
+}
+
+// lockingReadPattern matches selects that take row locks and must run on the primary
+var lockingReadPattern = regexp.MustCompile(`(?i)\bfor\s+(?:no\s+key\s+)?(?:update|share|key\s+share)\b`)
+
+// ReplicaConfig configures the read replicas of a DB
+type ReplicaConfig struct {
+	// Selection is the strategy used to pick a replica
+	Selection Selection
+	// HealthCheckInterval is how often replicas are pinged (default 5s)
+	HealthCheckInterval time.Duration
+	// Logger receives replica health changes, nothing is logged when nil
+	Logger *zerolog.Logger
+}
+
+// replica is a read only connection pool and its health
+type replica struct {
+	// pool is nil until the replica could be connected to
+	pool    *pgx.ConnPool
+	config  *pgx.ConnPoolConfig
+	host    string
+	port    string
+	healthy int32
+	mu      sync.RWMutex
+}
+
+// replicaSet tracks the replicas of a DB
+type replicaSet struct {
+	replicas  []*replica
+	selection Selection
+	next      uint64
+	logger    *zerolog.Logger
+	// inUse returns the connections in use of a replica, used by LeastConnections
+	inUse func(r *replica) int
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// primaryHint records that a request wrote to the primary, so that its following reads do too
+type primaryHint struct {
+	used int32
+}
+
+// ConnectWithReplicas creates a DB writing to the primary and reading from the given replicas
+// Only ReadQuery, ReadQueryRow and the pools returned by Reader read from the replicas, the other
+// statements are sent to the primary. Replicas are health checked in the background and reads fall back to the primary when none is healthy.
+// A replica that cannot be connected to is marked unhealthy and connected to again by the health checks.
+//
+// Return Values:
+//     1st: An error representing failure to connect to the primary
+func ConnectWithReplicas(primary *pgx.ConnPoolConfig, replicas []*pgx.ConnPoolConfig, config ReplicaConfig) (*DB, error) {
+	db, err := Connect(primary)
+	if err != nil {
+		return nil, err
+	}
+
+	set := newReplicaSet(replicas, config)
+
+	interval := config.HealthCheckInterval
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	set.done.Add(1)
+	go set.monitor(interval)
+
+	db.replicas = set
+
+	return db, nil
+}
+
+// newReplicaSet connects to the replicas, those that cannot be connected to start unhealthy
+func newReplicaSet(replicas []*pgx.ConnPoolConfig, config ReplicaConfig) *replicaSet {
+	set := &replicaSet{
+		selection: config.Selection,
+		logger:    config.Logger,
+		inUse:     inUse,
+		stop:      make(chan struct{}),
+	}
+
+	for _, replicaConfig := range replicas {
+		r := &replica{
+			config: replicaConfig,
+			host:   replicaConfig.Host,
+			port:   portString(replicaConfig.Port),
+		}
+
+		if err := r.connect(); err != nil {
+			if set.logger != nil {
+				set.logger.Error().Err(err).Str("host", r.host).Msg("postgres replica is unhealthy")
+			}
+		} else {
+			r.healthy = 1
+		}
+
+		set.replicas = append(set.replicas, r)
+	}
+
+	return set
+}
+
+// Writer returns the primary pool
+// The request of ctx is flagged so that its following reads are also sent to the primary.
+func (db *DB) Writer(ctx context.Context) *pgx.ConnPool {
+	markPrimary(ctx)
+	return db.Pool
+}
+
+// Reader returns a pool suitable for reads
+// This is a healthy replica, unless the DB has none, the context is flagged to use the primary,
+// or no replica is healthy, in which case it is the primary.
+func (db *DB) Reader(ctx context.Context) *pgx.ConnPool {
+	if r := db.reader(ctx); r != nil {
+		return r.connPool()
+	}
+	return db.Pool
+}
+
+// reader selects the replica for a read, nil meaning the primary
+func (db *DB) reader(ctx context.Context) *replica {
+	if db.replicas == nil || usePrimary(ctx) {
+		return nil
+	}
+	return db.replicas.pick()
+}
+
+// ReadYourWrites provides read-your-writes consistency for requests
+// Once a request writes to the primary, the rest of its reads are sent to the primary as well.
+func ReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), primaryHintKey, &primaryHint{})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// WithPrimary returns a context whose reads are sent to the primary
+// When the context already carries a ReadYourWrites hint, it is flagged in place so the rest of the request is affected.
+func WithPrimary(ctx context.Context) context.Context {
+	if hint, ok := ctx.Value(primaryHintKey).(*primaryHint); ok {
+		atomic.StoreInt32(&hint.used, 1)
+		return ctx
+	}
+	return context.WithValue(ctx, primaryHintKey, &primaryHint{used: 1})
+}
+
+func markPrimary(ctx context.Context) {
+	if hint, ok := ctx.Value(primaryHintKey).(*primaryHint); ok {
+		atomic.StoreInt32(&hint.used, 1)
+	}
+}
+
+func usePrimary(ctx context.Context) bool {
+	hint, ok := ctx.Value(primaryHintKey).(*primaryHint)
+	return ok && atomic.LoadInt32(&hint.used) == 1
+}
+
+// isReadOnlyStatement reports whether a statement given to ReadQuery may be sent to a replica
+func isReadOnlyStatement(operation string, sql string) bool {
+	return operation == "SELECT" && !lockingReadPattern.MatchString(sql)
+}
+
+// pick selects a healthy replica according to the configured strategy, nil when none is healthy
+func (s *replicaSet) pick() *replica {
+	var healthy []*replica
+	for _, r := range s.replicas {
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	if s.selection == LeastConnections {
+		best := healthy[0]
+		bestInUse := s.inUse(best)
+		for _, r := range healthy[1:] {
+			if n := s.inUse(r); n < bestInUse {
+				best, bestInUse = r, n
+			}
+		}
+		return best
+	}
+
+	n := atomic.AddUint64(&s.next, 1)
+	return healthy[(n-1)%uint64(len(healthy))]
+}
+
+func inUse(r *replica) int {
+	stat := r.stat()
+	return stat.CheckedOutConnections()
+}
+
+// connPool returns the pool of the replica, nil when it could not be connected to yet
+func (r *replica) connPool() *pgx.ConnPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// stat returns the statistics of the replica pool, empty when it could not be connected to yet
+func (r *replica) stat() pgx.ConnPoolStat {
+	if pool := r.connPool(); pool != nil {
+		return pool.Stat()
+	}
+	return pgx.ConnPoolStat{}
+}
+
+// connect creates the pool of the replica when it has none
+func (r *replica) connect() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pool != nil {
+		return nil
+	}
+
+	pool, err := pgx.NewConnPool(*r.config)
+	if err != nil {
+		return err
+	}
+	r.pool = pool
+	return nil
+}
+
+// monitor pings the replicas until the set is closed
+func (s *replicaSet) monitor(interval time.Duration) {
+	defer s.done.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			for _, r := range s.replicas {
+				s.check(r, interval)
+			}
+		}
+	}
+}
+
+func (s *replicaSet) check(r *replica, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := r.connect()
+	if err == nil {
+		err = ping(ctx, r.connPool())
+	}
+
+	var healthy int32
+	if err == nil {
+		healthy = 1
+	}
+
+	if atomic.SwapInt32(&r.healthy, healthy) != healthy && s.logger != nil {
+		if err != nil {
+			s.logger.Error().Err(err).Str("host", r.host).Msg("postgres replica is unhealthy")
+		} else {
+			s.logger.Info().Str("host", r.host).Msg("postgres replica is healthy")
+		}
+	}
+}
+
+func (s *replicaSet) close() {
+	close(s.stop)
+	s.done.Wait()
+	for _, r := range s.replicas {
+		if pool := r.connPool(); pool != nil {
+			pool.Close()
+		}
+	}
+}
+
+func ping(ctx context.Context, pool *pgx.ConnPool) error {
+	conn, err := pool.Acquire()
+	if err != nil {
+		return err
+	}
+	defer pool.Release(conn)
+
+	return conn.Ping(ctx)
+}
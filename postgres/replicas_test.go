@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaSetPickRoundRobin(t *testing.T) {
+	a, b := &replica{host: "a", healthy: 1}, &replica{host: "b", healthy: 1}
+	set := &replicaSet{replicas: []*replica{a, b}, selection: RoundRobin}
+
+	assert.Equal(t, []*replica{a, b, a, b}, []*replica{set.pick(), set.pick(), set.pick(), set.pick()}, "alternates replicas")
+
+	b.healthy = 0
+	assert.Equal(t, []*replica{a, a}, []*replica{set.pick(), set.pick()}, "skips unhealthy replicas")
+
+	a.healthy = 0
+	assert.Nil(t, set.pick(), "no healthy replica")
+}
+
+func TestReplicaSetPickLeastConnections(t *testing.T) {
+	a, b, c := &replica{host: "a", healthy: 1}, &replica{host: "b", healthy: 1}, &replica{host: "c", healthy: 1}
+	connections := map[*replica]int{a: 3, b: 1, c: 2}
+	set := &replicaSet{
+		replicas:  []*replica{a, b, c},
+		selection: LeastConnections,
+		inUse:     func(r *replica) int { return connections[r] },
+	}
+
+	assert.Equal(t, b, set.pick(), "fewest connections in use")
+	assert.Equal(t, b, set.pick(), "does not rotate")
+
+	connections[c] = 0
+	assert.Equal(t, c, set.pick(), "follows the connections in use")
+
+	c.healthy = 0
+	assert.Equal(t, b, set.pick(), "skips unhealthy replicas")
+
+	connections[a] = 1
+	assert.Equal(t, a, set.pick(), "first replica on a tie")
+
+	a.healthy, b.healthy = 0, 0
+	assert.Nil(t, set.pick(), "no healthy replica")
+}
+
+func TestDBReaderFallsBackToPrimary(t *testing.T) {
+	primary, replicaPool := &pgx.ConnPool{}, &pgx.ConnPool{}
+	r := &replica{pool: replicaPool, healthy: 1}
+	db := &DB{Pool: primary, replicas: &replicaSet{replicas: []*replica{r}}}
+
+	assert.True(t, replicaPool == db.Reader(context.Background()), "reads from the replica")
+	assert.True(t, primary == db.Reader(WithPrimary(context.Background())), "forced to primary")
+
+	r.healthy = 0
+	assert.True(t, primary == db.Reader(context.Background()), "unhealthy replica")
+
+	assert.True(t, primary == (&DB{Pool: primary}).Reader(context.Background()), "no replicas")
+}
+
+func TestNewReplicaSetUnreachableReplica(t *testing.T) {
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	unreachable := &pgx.ConnPoolConfig{ConnConfig: pgx.ConnConfig{Host: "127.0.0.1", Port: 1}}
+
+	set := newReplicaSet([]*pgx.ConnPoolConfig{unreachable}, ReplicaConfig{Logger: &logger})
+	defer set.close()
+
+	r := set.replicas[0]
+	assert.Nil(t, r.connPool(), "no pool")
+	assert.Nil(t, set.pick(), "unhealthy replica")
+	assert.Equal(t, pgx.ConnPoolStat{}, r.stat(), "empty statistics")
+	assert.Contains(t, logs.String(), "postgres replica is unhealthy")
+	assert.Contains(t, logs.String(), `"host":"127.0.0.1"`)
+
+	set.check(r, time.Second)
+	assert.Nil(t, r.connPool(), "connection retried")
+	assert.Nil(t, set.pick(), "still unhealthy")
+
+	db := &DB{Pool: &pgx.ConnPool{}, replicas: set}
+	assert.True(t, db.Pool == db.Reader(context.Background()), "reads from the primary")
+	assert.Equal(t, []pgx.ConnPoolStat{{}}, db.ReplicaStats())
+}
+
+func TestReadYourWrites(t *testing.T) {
+	primary, replicaPool := &pgx.ConnPool{}, &pgx.ConnPool{}
+	db := &DB{Pool: primary, replicas: &replicaSet{replicas: []*replica{{pool: replicaPool, healthy: 1}}}}
+
+	handler := ReadYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		assert.True(t, replicaPool == db.Reader(ctx), "reads from the replica before writing")
+		assert.True(t, primary == db.Writer(ctx), "writes to the primary")
+		assert.True(t, primary == db.Reader(ctx), "reads from the primary after writing")
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// without the middleware the write is not remembered
+	ctx := context.Background()
+	db.Writer(ctx)
+	assert.True(t, replicaPool == db.Reader(ctx))
+}
+
+func TestIsReadOnlyStatement(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{sql: "SELECT * FROM users", want: true},
+		{sql: "SELECT * FROM users FOR UPDATE", want: false},
+		{sql: "select * from users for no key update skip locked", want: false},
+		{sql: "SELECT * FROM users FOR SHARE", want: false},
+		{sql: "INSERT INTO users (id) VALUES (1) RETURNING id", want: false},
+		{sql: "WITH deleted AS (DELETE FROM users RETURNING id) SELECT id FROM deleted", want: false},
+	}
+	for _, tt := range tests {
+		operation, _, _ := StatementName(tt.sql)
+		assert.Equal(t, tt.want, isReadOnlyStatement(operation, tt.sql), tt.sql)
+	}
+}
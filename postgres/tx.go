@@ -0,0 +1,201 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+const (
+	// SQLStateSerializationFailure is raised when a serializable transaction cannot be committed
+	SQLStateSerializationFailure = "40001"
+	// SQLStateDeadlockDetected is raised when postgres aborts a transaction to break a deadlock
+	SQLStateDeadlockDetected = "40P01"
+
+	defaultTxMaxRetries   = 3
+	defaultTxRetryBackoff = 50 * time.Millisecond
+	defaultTxMaxBackoff   = time.Second
+)
+
+// TxFunc is the unit of work executed inside a transaction by WithTx
+// It may be invoked more than once, so it must not have side effects outside of the transaction
+type TxFunc func(tx *Tx) error
+
+// Tx is a transaction started by WithTx
+// Statements issued through Exec, Query and QueryRow are recorded like those of the DB, see DB.Instrument,
+// the other methods of pgx.Tx are not instrumented.
+type Tx struct {
+	*pgx.Tx
+
+	db *DB
+}
+
+// Exec executes sql with the given arguments inside the transaction, recording it
+func (tx *Tx) Exec(ctx context.Context, sql string, args ...interface{}) (pgx.CommandTag, error) {
+	end := tx.db.startStatement(ctx, sql, tx.db.Pool, tx.db.host, tx.db.port)
+
+	tag, err := tx.Tx.ExecEx(ctx, sql, nil, args...)
+	end(tag.RowsAffected(), err)
+
+	return tag, err
+}
+
+// Query executes sql with the given arguments inside the transaction,
+// the statement is recorded once the returned rows are closed
+func (tx *Tx) Query(ctx context.Context, sql string, args ...interface{}) (*Rows, error) {
+	end := tx.db.startStatement(ctx, sql, tx.db.Pool, tx.db.host, tx.db.port)
+
+	rows, err := tx.Tx.QueryEx(ctx, sql, nil, args...)
+	if err != nil {
+		end(0, err)
+		return nil, err
+	}
+
+	return &Rows{Rows: rows, end: end}, nil
+}
+
+// QueryRow executes sql with the given arguments inside the transaction,
+// the statement is recorded once the row is scanned
+func (tx *Tx) QueryRow(ctx context.Context, sql string, args ...interface{}) *Row {
+	rows, err := tx.Query(ctx, sql, args...)
+	return &Row{rows: rows, err: err}
+}
+
+// TxOptions configures the transaction started by WithTx
+type TxOptions struct {
+	// IsoLevel is the isolation level, the server default is used when empty
+	IsoLevel pgx.TxIsoLevel
+	// ReadOnly starts the transaction in read only access mode
+	ReadOnly bool
+	// Deferrable starts the transaction as deferrable, only meaningful for serializable read only transactions
+	Deferrable bool
+
+	// MaxRetries is the number of times the function is retried after a serialization failure or deadlock
+	// Zero uses the default of 3, a negative value disables retries
+	MaxRetries int
+	// RetryBackoff is the initial delay between attempts, doubled on each retry (default 50ms)
+	RetryBackoff time.Duration
+	// MaxBackoff caps the delay between attempts (default 1s)
+	MaxBackoff time.Duration
+}
+
+// WithTx runs fn inside a transaction
+// The transaction is rolled back if fn returns an error or panics, and committed otherwise.
+// When the transaction fails with a serialization failure or a deadlock (SQLSTATE 40001/40P01)
+// the whole function is retried with a bounded exponential backoff.
+// Transactions always run on the primary.
+//
+// Return Values:
+//     1st: The error returned by fn, or an error representing failure to begin/commit the transaction
+func (db *DB) WithTx(ctx context.Context, opts *TxOptions, fn TxFunc) error {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+
+	maxRetries := opts.maxRetries()
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = db.runTx(ctx, opts, fn)
+		if err == nil || !IsRetryableError(err) || attempt >= maxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(opts.backoff(attempt)):
+		}
+	}
+}
+
+// runTx performs a single attempt of WithTx
+func (db *DB) runTx(ctx context.Context, opts *TxOptions, fn TxFunc) (err error) {
+	pgxTx, err := db.Writer(ctx).BeginEx(ctx, opts.pgxOptions())
+	if err != nil {
+		return err
+	}
+	tx := &Tx{Tx: pgxTx, db: db}
+
+	defer func() {
+		if p := recover(); p != nil {
+			// the original panic is more useful than a rollback failure
+			_ = tx.RollbackEx(ctx)
+			panic(p)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if rbErr := tx.RollbackEx(ctx); rbErr != nil && rbErr != pgx.ErrTxClosed {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.CommitEx(ctx)
+}
+
+// IsRetryableError reports whether err is a serialization failure or a deadlock,
+// meaning the transaction can succeed if it is run again
+// The error may be wrapped, e.g. by the error of a failed rollback.
+func IsRetryableError(err error) bool {
+	var code string
+
+	var pgErr pgx.PgError
+	var pgErrPtr *pgx.PgError
+	if errors.As(err, &pgErr) {
+		code = pgErr.Code
+	} else if errors.As(err, &pgErrPtr) && pgErrPtr != nil {
+		code = pgErrPtr.Code
+	}
+
+	return code == SQLStateSerializationFailure || code == SQLStateDeadlockDetected
+}
+
+func (o *TxOptions) pgxOptions() *pgx.TxOptions {
+	txOptions := &pgx.TxOptions{IsoLevel: o.IsoLevel}
+	if o.ReadOnly {
+		txOptions.AccessMode = pgx.ReadOnly
+	}
+	if o.Deferrable {
+		txOptions.DeferrableMode = pgx.Deferrable
+	}
+	return txOptions
+}
+
+func (o *TxOptions) maxRetries() int {
+	switch {
+	case o.MaxRetries < 0:
+		return 0
+	case o.MaxRetries == 0:
+		return defaultTxMaxRetries
+	}
+	return o.MaxRetries
+}
+
+// backoff returns the delay before the retry following the given attempt,
+// an exponential backoff capped by MaxBackoff with up to 50% jitter
+func (o *TxOptions) backoff(attempt int) time.Duration {
+	base := o.RetryBackoff
+	if base <= 0 {
+		base = defaultTxRetryBackoff
+	}
+	max := o.MaxBackoff
+	if max <= 0 {
+		max = defaultTxMaxBackoff
+	}
+
+	delay := base
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
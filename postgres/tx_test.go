@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "serialization failure",
+			err:  pgx.PgError{Code: SQLStateSerializationFailure},
+			want: true,
+		},
+		{
+			name: "deadlock",
+			err:  &pgx.PgError{Code: SQLStateDeadlockDetected},
+			want: true,
+		},
+		{
+			name: "unique violation",
+			err:  pgx.PgError{Code: "23505"},
+			want: false,
+		},
+		{
+			name: "wrapped serialization failure",
+			err:  fmt.Errorf("update: %w", pgx.PgError{Code: SQLStateSerializationFailure}),
+			want: true,
+		},
+		{
+			name: "wrapped deadlock",
+			err:  fmt.Errorf("%w (rollback failed: %v)", &pgx.PgError{Code: SQLStateDeadlockDetected}, errors.New("conn busy")),
+			want: true,
+		},
+		{
+			name: "wrapped unique violation",
+			err:  fmt.Errorf("insert: %w", &pgx.PgError{Code: "23505"}),
+			want: false,
+		},
+		{
+			name: "plain error",
+			err:  errors.New("40001"),
+			want: false,
+		},
+		{
+			name: "nil",
+			err:  nil,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryableError(tt.err))
+		})
+	}
+}
+
+func TestTxOptionsMaxRetries(t *testing.T) {
+	assert.Equal(t, defaultTxMaxRetries, (&TxOptions{}).maxRetries(), "zero uses the default")
+	assert.Equal(t, 0, (&TxOptions{MaxRetries: -1}).maxRetries(), "negative disables retries")
+	assert.Equal(t, 7, (&TxOptions{MaxRetries: 7}).maxRetries(), "explicit value is kept")
+}
+
+func TestTxOptionsBackoff(t *testing.T) {
+	opts := &TxOptions{RetryBackoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+
+	for attempt, ceiling := range []time.Duration{10, 20, 40, 40, 40} {
+		ceiling *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			got := opts.backoff(attempt)
+			assert.True(t, got >= ceiling/2 && got <= ceiling, "attempt %d: %v not within [%v, %v]", attempt, got, ceiling/2, ceiling)
+		}
+	}
+}
+
+func TestTxOptionsPgxOptions(t *testing.T) {
+	got := (&TxOptions{IsoLevel: pgx.Serializable, ReadOnly: true, Deferrable: true}).pgxOptions()
+
+	assert.Equal(t, &pgx.TxOptions{
+		IsoLevel:       pgx.Serializable,
+		AccessMode:     pgx.ReadOnly,
+		DeferrableMode: pgx.Deferrable,
+	}, got)
+}
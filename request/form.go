@@ -0,0 +1,132 @@
+package request
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// textUnmarshalerType is used to bind values of types parsing themselves, e.g. time.Time
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// bindValues sets the fields of the struct pointed to by dst from url values
+// Fields are matched by their `form` tag, falling back to their `json` tag then their name.
+// Supported kinds are strings, booleans, numbers, types implementing encoding.TextUnmarshaler,
+// pointers to those and slices of those, which take every value of the key. Embedded structs are flattened.
+func bindValues(values url.Values, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("destination must be a pointer to a struct, got %T", dst)
+	}
+
+	return bindStruct(values, v.Elem())
+}
+
+func bindStruct(values url.Values, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := bindStruct(values, v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := formName(field)
+		raw, ok := values[name]
+		if name == "" || !ok || len(raw) == 0 {
+			continue
+		}
+
+		if err := bindField(v.Field(i), raw); err != nil {
+			return &FieldError{Field: name, Value: raw[0], Err: err}
+		}
+	}
+
+	return nil
+}
+
+// formName returns the form name of a struct field
+func formName(field reflect.StructField) string {
+	for _, key := range []string{"form", "json"} {
+		name := strings.SplitN(field.Tag.Get(key), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func bindField(v reflect.Value, raw []string) error {
+	if v.Kind() == reflect.Slice && !reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(v.Type(), len(raw), len(raw))
+		for i, s := range raw {
+			if err := bindScalar(slice.Index(i), s); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	return bindScalar(v, raw[0])
+}
+
+func bindScalar(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		ptr := reflect.New(v.Type().Elem())
+		if err := bindScalar(ptr.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+
+	return nil
+}
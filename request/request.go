@@ -0,0 +1,234 @@
+// Package request decodes and validates request bodies and query strings, pairing with the response package
+// to answer malformed or invalid requests.
+package request
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apperr"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+)
+
+// DefaultMaxBytes is the default size limit of request bodies (1MB)
+const DefaultMaxBytes int64 = 1 << 20
+
+var (
+	// ErrBodyTooLarge is returned when a request body exceeds the size limit
+	ErrBodyTooLarge = errors.New("request body too large")
+	// ErrEmptyBody is returned when a request body is required but empty
+	ErrEmptyBody = errors.New("request body is empty")
+	// ErrUnsupportedMediaType is returned when a request body is neither JSON nor a form
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// FieldError is returned when a field value cannot be decoded into its type
+type FieldError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+// Error implements error
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid value %q for field %s: %v", e.Value, e.Field, e.Err)
+}
+
+// Unwrap returns the decoding error
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Options configures Decode
+type Options struct {
+	// MaxBytes is the size limit of the body (default DefaultMaxBytes)
+	MaxBytes int64
+	// DisallowUnknownFields rejects JSON bodies with fields missing from the destination
+	DisallowUnknownFields bool
+	// SkipValidation decodes without validating
+	SkipValidation bool
+}
+
+// Decode decodes the body of a request into the struct pointed to by dst, then validates it
+// JSON bodies are decoded with encoding/json, form bodies (urlencoded or multipart) are bound as described
+// by DecodeQuery. A missing Content-Type is treated as JSON.
+//
+// Return Values:
+//     1st: An *apperr.Error describing the failure, ready to be returned to middleware.ErrorObserver:
+//          400 when the body is malformed or empty, 413 when it is too large, 415 for other media types
+//          and 422 with the validation failures, using JSON field names
+func Decode(r *http.Request, dst interface{}, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+
+	mediaType := ""
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return apperr.Wrap(err, http.StatusUnsupportedMediaType, "Unsupported content type")
+		}
+	}
+
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	r.Body = ioutil.NopCloser(&limitedReader{r: r.Body, n: maxBytes})
+
+	var err error
+	switch {
+	case mediaType == "" || mediaType == response.ContentTypeJSON || strings.HasSuffix(mediaType, "+json"):
+		err = decodeJSON(r.Body, dst, opts.DisallowUnknownFields)
+	case mediaType == "application/x-www-form-urlencoded":
+		if err = r.ParseForm(); err == nil {
+			err = bindValues(r.PostForm, dst)
+		}
+	case mediaType == "multipart/form-data":
+		if err = r.ParseMultipartForm(maxBytes); err == nil {
+			err = bindValues(r.MultipartForm.Value, dst)
+		}
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+	if err != nil {
+		return decodeError(err)
+	}
+
+	if opts.SkipValidation {
+		return nil
+	}
+
+	return validateStruct(dst)
+}
+
+// DecodeQuery binds the query string of a request into the struct pointed to by dst, then validates it
+// Fields are matched by their `form` tag, falling back to their `json` tag then their name.
+// Strings, booleans, numbers, encoding.TextUnmarshaler implementations, pointers and slices of those are supported.
+//
+// Return Values:
+//     1st: An *apperr.Error describing the failure: 400 when a value is malformed, 422 with the validation failures
+func DecodeQuery(r *http.Request, dst interface{}) error {
+	if err := bindValues(r.URL.Query(), dst); err != nil {
+		return decodeError(err)
+	}
+
+	return validateStruct(dst)
+}
+
+// Bind decodes and validates a request body like Decode, and sends the error response on failure
+// Handlers should return when it reports false:
+//
+//     var input CreateUser
+//     if !request.Bind(w, r, &input, nil) {
+//         return
+//     }
+func Bind(w http.ResponseWriter, r *http.Request, dst interface{}, opts *Options) bool {
+	return send(w, r, Decode(r, dst, opts))
+}
+
+// BindQuery decodes and validates a query string like DecodeQuery, and sends the error response on failure
+func BindQuery(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	return send(w, r, DecodeQuery(r, dst))
+}
+
+func send(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	response.SendFor(w, r, apperr.From(err).Response())
+	return false
+}
+
+func decodeJSON(body io.Reader, dst interface{}, disallowUnknownFields bool) error {
+	decoder := json.NewDecoder(body)
+	if disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(dst); err != nil {
+		if err == io.EOF {
+			return ErrEmptyBody
+		}
+		return err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		if errors.Is(err, ErrBodyTooLarge) {
+			return err
+		}
+		return errors.New("request body must contain a single JSON value")
+	}
+
+	return nil
+}
+
+// decodeError converts a decoding failure into an application error with a message safe for clients
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var fieldErr *FieldError
+
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		return apperr.Wrap(err, http.StatusRequestEntityTooLarge, "Request body too large")
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return apperr.Wrap(err, http.StatusUnsupportedMediaType, "Unsupported content type")
+	case errors.Is(err, ErrEmptyBody):
+		return apperr.Wrap(err, http.StatusBadRequest, "Request body is empty")
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return apperr.Wrap(err, http.StatusBadRequest, "Request body is malformed JSON")
+	case errors.As(err, &typeErr):
+		return apperr.Wrap(err, http.StatusBadRequest, fmt.Sprintf("Invalid value for field %s", typeErr.Field))
+	case errors.As(err, &fieldErr):
+		return apperr.Wrap(err, http.StatusBadRequest, fmt.Sprintf("Invalid value for field %s", fieldErr.Field))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return apperr.Wrap(err, http.StatusBadRequest, "Unknown field "+strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+
+	return apperr.Wrap(err, http.StatusBadRequest, "Request body is malformed")
+}
+
+// validateStruct validates a decoded struct into an application error
+func validateStruct(dst interface{}) error {
+	failures, err := Validate(dst)
+	if err != nil {
+		return apperr.Internal(err)
+	}
+	if len(failures) > 0 {
+		return apperr.Validation("Validation failed", failures...)
+	}
+	return nil
+}
+
+// limitedReader fails with ErrBodyTooLarge once more than n bytes are read
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
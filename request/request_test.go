@@ -0,0 +1,137 @@
+package request
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apperr"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/jsontime"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"github.com/stretchr/testify/assert"
+)
+
+type address struct {
+	Zip string `json:"zip" validate:"required,len=5"`
+}
+
+type event struct {
+	Title    string           `json:"title" validate:"required"`
+	Capacity int              `json:"capacity" validate:"min=1"`
+	Date     jsontime.Date    `json:"date" validate:"required"`
+	Tags     []string         `json:"tags" form:"tag"`
+	Address  *address         `json:"address" validate:"omitempty"`
+	Private  bool             `json:"private"`
+	Starts   *time.Time       `json:"starts"`
+	Internal string           `json:"-"`
+	Extra    *json.RawMessage `json:"extra,omitempty" form:"-"`
+}
+
+func newRequest(contentType string, body string) *http.Request {
+	r := httptest.NewRequest("POST", "/events", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var e event
+	r := newRequest("application/json; charset=utf-8", `{"title":"Circle time","capacity":12,"date":"2019-06-01","tags":["a","b"]}`)
+
+	assert.NoError(t, Decode(r, &e, nil))
+	assert.Equal(t, "Circle time", e.Title)
+	assert.Equal(t, []string{"a", "b"}, e.Tags)
+	assert.Equal(t, "2019-06-01", e.Date.String())
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		opts        *Options
+		status      int
+		message     string
+	}{
+		{"empty", "", "", nil, http.StatusBadRequest, "Request body is empty"},
+		{"syntax", "", `{"title":`, nil, http.StatusBadRequest, "Request body is malformed JSON"},
+		{"type", "", `{"capacity":"many"}`, nil, http.StatusBadRequest, "Invalid value for field capacity"},
+		{"trailing", "", `{"title":"a"} {}`, nil, http.StatusBadRequest, "Request body is malformed"},
+		{"unknown allowed", "", `{"title":"a","capacity":1,"date":"2019-06-01","color":"red"}`, nil, 0, ""},
+		{"unknown", "", `{"color":"red"}`, &Options{DisallowUnknownFields: true}, http.StatusBadRequest, `Unknown field "color"`},
+		{"too large", "", `{"title":"` + strings.Repeat("a", 64) + `"}`, &Options{MaxBytes: 32}, http.StatusRequestEntityTooLarge, "Request body too large"},
+		{"media type", "text/plain", `title`, nil, http.StatusUnsupportedMediaType, "Unsupported content type"},
+		{"form value", "application/x-www-form-urlencoded", `capacity=many`, nil, http.StatusBadRequest, "Invalid value for field capacity"},
+	}
+
+	for _, test := range tests {
+		var e event
+		err := Decode(newRequest(test.contentType, test.body), &e, test.opts)
+		if test.status == 0 {
+			assert.NoError(t, err, test.name)
+			continue
+		}
+
+		appErr, ok := err.(*apperr.Error)
+		if assert.True(t, ok, test.name) {
+			assert.Equal(t, test.status, appErr.Status, test.name)
+			assert.Equal(t, test.message, appErr.Message, test.name)
+		}
+	}
+}
+
+func TestDecodeValidation(t *testing.T) {
+	var e event
+	err := Decode(newRequest("", `{"capacity":0,"address":{"zip":"123"}}`), &e, nil)
+
+	appErr, ok := err.(*apperr.Error)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusUnprocessableEntity, appErr.Status)
+
+	fields := map[string]string{}
+	for _, f := range appErr.Validations {
+		fields[f.Field()] = f.Tag()
+	}
+	assert.Equal(t, map[string]string{"title": "required", "capacity": "min", "date": "required", "address.zip": "len"}, fields)
+}
+
+func TestDecodeForm(t *testing.T) {
+	var e event
+	r := newRequest("application/x-www-form-urlencoded", `title=Circle+time&capacity=3&tag=a&tag=b&private=true&starts=2019-06-01T09:00:00Z`)
+
+	err := Decode(r, &e, nil)
+	appErr, ok := err.(*apperr.Error)
+	assert.True(t, ok, "date is required")
+	assert.Equal(t, "date", appErr.Validations[0].Field())
+
+	assert.Equal(t, "Circle time", e.Title)
+	assert.Equal(t, 3, e.Capacity)
+	assert.Equal(t, []string{"a", "b"}, e.Tags)
+	assert.True(t, e.Private)
+	assert.Equal(t, time.Date(2019, 6, 1, 9, 0, 0, 0, time.UTC), *e.Starts)
+}
+
+func TestBindQuery(t *testing.T) {
+	type search struct {
+		Query string `form:"q" validate:"required"`
+		Page  uint   `form:"page"`
+	}
+
+	var s search
+	w := httptest.NewRecorder()
+	assert.True(t, BindQuery(w, httptest.NewRequest("GET", "/search?q=art&page=2", nil), &s))
+	assert.Equal(t, search{Query: "art", Page: 2}, s)
+
+	w = httptest.NewRecorder()
+	assert.False(t, BindQuery(w, httptest.NewRequest("GET", "/search?page=2", nil), &search{}))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var body response.StandardBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "q", (*body.Validations)[0].Field)
+	assert.Equal(t, "required", (*body.Validations)[0].Tag)
+}
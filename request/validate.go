@@ -0,0 +1,85 @@
+package request
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/jsontime"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/response"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+var (
+	validate     *validator.Validate
+	validateOnce sync.Once
+)
+
+// Validator returns the validator used by Decode and Validate
+// It has the jsontime types registered, and reports fields by their JSON name.
+// Custom validations may be registered on it before serving requests.
+func Validator() *validator.Validate {
+	validateOnce.Do(func() {
+		validate = validator.New()
+		validate.RegisterCustomTypeFunc(jsontime.TimerValuer, jsontime.Date{}, jsontime.DateTime{})
+		validate.RegisterTagNameFunc(fieldName)
+	})
+	return validate
+}
+
+// Validate validates a struct with Validator
+//
+// Return Values:
+//     1st: The validation failures, with the dotted JSON path of the fields, e.g. "address.zip"
+//     2nd: An error representing failure to validate, e.g. dst is not a struct
+func Validate(dst interface{}) ([]response.ValidationFailure, error) {
+	err := Validator().Struct(dst)
+	if err == nil {
+		return nil, nil
+	}
+
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return nil, err
+	}
+
+	failures := make([]response.ValidationFailure, len(errs))
+	for i, e := range errs {
+		failures[i] = fieldFailure{FieldError: e, field: fieldPath(e.Namespace())}
+	}
+
+	return failures, nil
+}
+
+// fieldFailure reports a validation failure with the JSON path of its field
+type fieldFailure struct {
+	validator.FieldError
+	field string
+}
+
+// Field returns the JSON path of the field
+func (f fieldFailure) Field() string {
+	return f.field
+}
+
+// fieldPath strips the struct name from a validator namespace, e.g. "User.address.zip" becomes "address.zip"
+func fieldPath(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+// fieldName returns the JSON name of a struct field, falling back to its form name, then its Go name
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "form"} {
+		name := strings.SplitN(field.Tag.Get(key), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
@@ -0,0 +1,92 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetHeader sets an HTTP header using canonical header case, replacing any previous values
+func (r *StandardResponse) SetHeader(key string, value string) {
+	if r.headers == nil {
+		r.headers = map[string][]string{}
+	}
+	r.headers[http.CanonicalHeaderKey(key)] = []string{value}
+}
+
+// SetCacheControl sets the Cache-Control header to the given directives
+func (r *StandardResponse) SetCacheControl(directives ...string) {
+	r.SetHeader("Cache-Control", strings.Join(directives, ", "))
+}
+
+// CachePublic allows any cache to store the response for maxAge
+func (r *StandardResponse) CachePublic(maxAge time.Duration) {
+	r.SetCacheControl("public", maxAgeDirective(maxAge))
+}
+
+// CachePrivate allows only the client to store the response for maxAge, e.g. for user specific data
+func (r *StandardResponse) CachePrivate(maxAge time.Duration) {
+	r.SetCacheControl("private", maxAgeDirective(maxAge))
+}
+
+// NoCache allows the response to be stored, but requires revalidation with the ETag before each reuse
+func (r *StandardResponse) NoCache() {
+	r.SetCacheControl("no-cache")
+}
+
+// NoStore forbids storing the response, e.g. for sensitive data
+func (r *StandardResponse) NoStore() {
+	r.SetCacheControl("no-store")
+}
+
+// SetLastModified sets the Last-Modified header, used to answer If-Modified-Since requests
+func (r *StandardResponse) SetLastModified(t time.Time) {
+	r.lastModified = t.UTC().Truncate(time.Second)
+	r.SetHeader("Last-Modified", r.lastModified.Format(http.TimeFormat))
+}
+
+func maxAgeDirective(maxAge time.Duration) string {
+	return "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+}
+
+// ETag returns the strong entity tag of a marshalled body
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// isCacheable reports whether a response to the request gets an ETag and honors conditional headers
+func isCacheable(r *http.Request, sr *StandardResponse) bool {
+	return r != nil &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		sr.StatusCode == http.StatusOK
+}
+
+// notModified evaluates the conditional headers of a request against the response validators
+// If-None-Match takes precedence over If-Modified-Since, as required by RFC 7232.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.After(t)
+	}
+
+	return false
+}
+
+// etagMatches performs the weak comparison of If-None-Match
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
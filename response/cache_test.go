@@ -0,0 +1,89 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheControl(t *testing.T) {
+	tests := []struct {
+		set      func(sr *StandardResponse)
+		expected string
+	}{
+		{func(sr *StandardResponse) { sr.CachePublic(time.Hour) }, "public, max-age=3600"},
+		{func(sr *StandardResponse) { sr.CachePrivate(90 * time.Second) }, "private, max-age=90"},
+		{func(sr *StandardResponse) { sr.NoCache() }, "no-cache"},
+		{func(sr *StandardResponse) { sr.NoStore() }, "no-store"},
+		{func(sr *StandardResponse) { sr.SetCacheControl("no-cache", "must-revalidate") }, "no-cache, must-revalidate"},
+	}
+
+	for _, test := range tests {
+		sr := New()
+		sr.StatusCode = http.StatusOK
+		sr.CachePublic(time.Minute)
+		test.set(sr)
+
+		w := httptest.NewRecorder()
+		assert.NoError(t, Send(w, sr))
+		assert.Equal(t, []string{test.expected}, w.Header()["Cache-Control"])
+	}
+}
+
+func TestSendForConditional(t *testing.T) {
+	modified := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	newResponse := func() *StandardResponse {
+		sr := New()
+		sr.StatusCode = http.StatusOK
+		sr.Data = map[string]int{"id": 1}
+		sr.SetLastModified(modified)
+		return sr
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/items/1", nil)
+	assert.NoError(t, SendFor(w, r, newResponse()))
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ETag(w.Body.Bytes()), etag)
+	assert.Equal(t, "Sat, 01 Jun 2019 12:00:00 GMT", w.Header().Get("Last-Modified"))
+
+	tests := []struct {
+		header   string
+		value    string
+		expected int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match", `"other", W/` + etag, http.StatusNotModified},
+		{"If-None-Match", "*", http.StatusNotModified},
+		{"If-None-Match", `"other"`, http.StatusOK},
+		{"If-Modified-Since", modified.Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", modified.Add(-time.Second).Format(http.TimeFormat), http.StatusOK},
+		{"If-Modified-Since", "yesterday", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/items/1", nil)
+		r.Header.Set(test.header, test.value)
+		assert.NoError(t, SendFor(w, r, newResponse()))
+		assert.Equal(t, test.expected, w.Code, test.header+": "+test.value)
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		if test.expected == http.StatusNotModified {
+			assert.Equal(t, 0, w.Body.Len())
+		}
+	}
+
+	// unsafe methods and errors are not conditional
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/items", nil)
+	r.Header.Set("If-None-Match", "*")
+	sr := newResponse()
+	sr.StatusCode = http.StatusCreated
+	assert.NoError(t, SendFor(w, r, sr))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "", w.Header().Get("ETag"))
+}
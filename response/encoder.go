@@ -0,0 +1,452 @@
+package response
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	// ContentTypeXML is the media type of XML encoded responses
+	ContentTypeXML = "application/xml"
+	// ContentTypeMsgpack is the media type of MessagePack encoded responses
+	ContentTypeMsgpack = "application/msgpack"
+	// ContentTypeCSV is the media type of CSV encoded list responses
+	ContentTypeCSV = "text/csv"
+)
+
+var (
+	// ErrNotAcceptable is returned by negotiation when no encoder matches the request Accept header
+	// SendFor answers it with a 406 itself, it is not returned to callers.
+	ErrNotAcceptable = errors.New("no acceptable representation")
+	// ErrUnsupportedBody is returned by encoders that cannot represent a body, e.g. CSV for non list data
+	ErrUnsupportedBody = errors.New("body cannot be represented in this format")
+)
+
+// Encoder serializes response bodies for a media type
+type Encoder interface {
+	ContentType() string
+	// Encode writes body, a *StandardBody or a *Problem, returning ErrUnsupportedBody
+	// when it cannot be represented
+	Encode(w io.Writer, body interface{}) error
+}
+
+var (
+	encodersMu sync.RWMutex
+	// encoders are tried in order when the client accepts several of them equally, JSON first
+	encoders = []Encoder{JSONEncoder{}, XMLEncoder{}, MsgpackEncoder{}, CSVEncoder{}}
+)
+
+// RegisterEncoder makes an encoder available to SendFor, replacing the one with the same content type
+func RegisterEncoder(e Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	for i, existing := range encoders {
+		if existing.ContentType() == e.ContentType() {
+			encoders[i] = e
+			return
+		}
+	}
+	encoders = append(encoders, e)
+}
+
+// negotiate returns the encoders acceptable for an Accept header, most preferred first
+func negotiate(accept string) []Encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	var candidates []Encoder
+	seen := map[string]bool{}
+	excluded := map[string]bool{}
+
+	ranges := parseAccept(accept)
+	for _, mr := range ranges {
+		if mr.q <= 0 && specificity(mr.mediaType) == 2 {
+			excluded[mr.mediaType] = true
+		}
+	}
+
+	for _, mr := range ranges {
+		if mr.q <= 0 {
+			continue
+		}
+		if mr.mediaType == ContentTypeProblemJSON {
+			// clients asking for problem details get plain JSON for successful responses
+			mr.mediaType = ContentTypeJSON
+		}
+		for _, e := range encoders {
+			contentType := e.ContentType()
+			if !seen[contentType] && !excluded[contentType] && mr.matches(contentType) {
+				seen[contentType] = true
+				candidates = append(candidates, e)
+			}
+		}
+	}
+
+	return candidates
+}
+
+// encode serializes body with the first candidate able to represent it
+func encode(candidates []Encoder, body interface{}) ([]byte, string, error) {
+	for _, e := range candidates {
+		var buf bytes.Buffer
+		err := e.Encode(&buf, body)
+		if err == ErrUnsupportedBody {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), e.ContentType(), nil
+	}
+
+	return nil, "", ErrNotAcceptable
+}
+
+// JSONEncoder encodes bodies as JSON
+type JSONEncoder struct{}
+
+// ContentType implements Encoder
+func (JSONEncoder) ContentType() string { return ContentTypeJSON }
+
+// Encode implements Encoder
+func (JSONEncoder) Encode(w io.Writer, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// problemEncoder encodes problem details as JSON
+type problemEncoder struct {
+	JSONEncoder
+}
+
+func (problemEncoder) ContentType() string { return ContentTypeProblemJSON }
+
+// XMLEncoder encodes bodies as XML with a `response` root element
+// Maps with string keys, as data pruned to the selected fields, are rendered as an element per key
+// in key order. Other data that encoding/xml cannot represent is reported as ErrUnsupportedBody.
+type XMLEncoder struct{}
+
+// ContentType implements Encoder
+func (XMLEncoder) ContentType() string { return ContentTypeXML }
+
+// Encode implements Encoder
+func (XMLEncoder) Encode(w io.Writer, body interface{}) error {
+	if sb, ok := body.(*StandardBody); ok && hasXMLMaps(sb.Data) {
+		wrapped := *sb
+		wrapped.Data = xmlValue{sb.Data}
+		body = &wrapped
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).EncodeElement(body, xml.StartElement{Name: xml.Name{Local: "response"}}); err != nil {
+		if _, ok := err.(*xml.UnsupportedTypeError); ok {
+			return ErrUnsupportedBody
+		}
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// hasXMLMaps reports whether data is a map, or a slice of maps or interfaces, which xmlValue renders
+func hasXMLMaps(data interface{}) bool {
+	t := reflect.TypeOf(data)
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+		return t.Kind() == reflect.Map || t.Kind() == reflect.Interface
+	}
+	return t.Kind() == reflect.Map
+}
+
+// xmlValue renders maps with string keys as an element per key, and the elements of slices with the
+// name of the slice, like encoding/xml does for struct fields
+type xmlValue struct {
+	v interface{}
+}
+
+func (x xmlValue) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	v := reflect.ValueOf(x.v)
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return &xml.UnsupportedTypeError{Type: v.Type()}
+		}
+
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := e.EncodeElement(xmlValue{v.MapIndex(key).Interface()}, xml.StartElement{Name: xml.Name{Local: key.String()}}); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := e.EncodeElement(xmlValue{v.Index(i).Interface()}, start); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return e.EncodeElement(x.v, start)
+}
+
+// MsgpackEncoder encodes bodies as MessagePack, using the JSON field names
+type MsgpackEncoder struct{}
+
+// ContentType implements Encoder
+func (MsgpackEncoder) ContentType() string { return ContentTypeMsgpack }
+
+// Encode implements Encoder
+func (MsgpackEncoder) Encode(w io.Writer, body interface{}) error {
+	return msgpack.NewEncoder(w).UseJSONTag(true).SortMapKeys(true).Encode(body)
+}
+
+// CSVEncoder encodes list data as CSV, one row per element with a header row
+// Elements may be structs, whose columns are named after their JSON fields, maps or scalars.
+// The envelope (message, status, pagination) is not part of the output, and any other
+// body is reported as ErrUnsupportedBody.
+type CSVEncoder struct{}
+
+// ContentType implements Encoder
+func (CSVEncoder) ContentType() string { return ContentTypeCSV }
+
+// Encode implements Encoder
+func (CSVEncoder) Encode(w io.Writer, body interface{}) error {
+	sb, ok := body.(*StandardBody)
+	if !ok {
+		return ErrUnsupportedBody
+	}
+
+	list := indirect(reflect.ValueOf(sb.Data))
+	if !list.IsValid() || (list.Kind() != reflect.Slice && list.Kind() != reflect.Array) {
+		return ErrUnsupportedBody
+	}
+
+	header, rows, err := csvRows(list)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
+
+// csvColumn is a struct field exported as a CSV column
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+func csvRows(list reflect.Value) ([]string, [][]string, error) {
+	elemType := list.Type().Elem()
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+
+	switch elemType.Kind() {
+	case reflect.Struct:
+		columns := structColumns(elemType, nil)
+		header := make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = c.name
+		}
+
+		rows := make([][]string, 0, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			elem := indirect(list.Index(i))
+			row := make([]string, len(columns))
+			if elem.IsValid() {
+				for j, c := range columns {
+					row[j] = csvValue(fieldByIndex(elem, c.index))
+				}
+			}
+			rows = append(rows, row)
+		}
+		return header, rows, nil
+
+	case reflect.Map, reflect.Interface:
+		keySet := map[string]struct{}{}
+		for i := 0; i < list.Len(); i++ {
+			elem := indirect(list.Index(i))
+			if !elem.IsValid() {
+				continue
+			}
+			if elem.Kind() != reflect.Map || elem.Type().Key().Kind() != reflect.String {
+				return nil, nil, ErrUnsupportedBody
+			}
+			for _, k := range elem.MapKeys() {
+				keySet[k.String()] = struct{}{}
+			}
+		}
+
+		header := make([]string, 0, len(keySet))
+		for k := range keySet {
+			header = append(header, k)
+		}
+		sort.Strings(header)
+
+		rows := make([][]string, 0, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			elem := indirect(list.Index(i))
+			row := make([]string, len(header))
+			if elem.IsValid() {
+				for j, k := range header {
+					row[j] = csvValue(elem.MapIndex(reflect.ValueOf(k).Convert(elem.Type().Key())))
+				}
+			}
+			rows = append(rows, row)
+		}
+		return header, rows, nil
+	}
+
+	rows := make([][]string, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		rows = append(rows, []string{csvValue(list.Index(i))})
+	}
+	return []string{"value"}, rows, nil
+}
+
+// structColumns lists the JSON visible fields of a struct, flattening embedded structs
+func structColumns(t reflect.Type, index []int) []csvColumn {
+	var columns []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts := jsonTag(field)
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		fieldIndex := append(append([]int{}, index...), i)
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			columns = append(columns, structColumns(fieldType, fieldIndex)...)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		columns = append(columns, csvColumn{name: name, index: fieldIndex})
+	}
+	return columns
+}
+
+func jsonTag(field reflect.StructField) (string, string) {
+	tag := field.Tag.Get("json")
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex without panicking on nil embedded pointers
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		v = indirect(v)
+		if !v.IsValid() {
+			return v
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// csvValue formats a single cell
+func csvValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return ""
+	}
+
+	if v.CanInterface() {
+		switch value := v.Interface().(type) {
+		case encoding.TextMarshaler:
+			if b, err := value.MarshalText(); err == nil {
+				return string(b)
+			}
+		case json.Marshaler:
+			if b, err := value.MarshalJSON(); err == nil {
+				var s string
+				if json.Unmarshal(b, &s) == nil {
+					return s
+				}
+				return string(b)
+			}
+		}
+	}
+
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface())
+	case reflect.Invalid:
+		return ""
+	}
+
+	// nested values keep their JSON representation
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	return string(b)
+}
@@ -0,0 +1,128 @@
+package response
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+type encodedItem struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	secret  string
+	Hidden  string `json:"-"`
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   []string
+	}{
+		{accept: "", want: []string{ContentTypeJSON, ContentTypeXML, ContentTypeMsgpack, ContentTypeCSV}},
+		{accept: "text/csv", want: []string{ContentTypeCSV}},
+		{accept: "application/xml;q=0.9, application/json", want: []string{ContentTypeJSON, ContentTypeXML}},
+		{accept: "application/*;q=0.5, text/csv", want: []string{ContentTypeCSV, ContentTypeJSON, ContentTypeXML, ContentTypeMsgpack}},
+		{accept: "*/*, application/json;q=0", want: []string{ContentTypeXML, ContentTypeMsgpack, ContentTypeCSV}},
+		{accept: "image/png", want: nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, e := range negotiate(tt.accept) {
+			got = append(got, e.ContentType())
+		}
+		assert.Equal(t, tt.want, got, tt.accept)
+	}
+}
+
+func TestSendForNegotiation(t *testing.T) {
+	created := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	newList := func() *StandardResponse {
+		res := New()
+		res.StatusCode = http.StatusOK
+		res.Message = "ok"
+		res.Data = []encodedItem{{ID: 1, Name: "Ann, B", Created: created, secret: "s"}, {ID: 2, Name: "Bob"}}
+		return res
+	}
+
+	send := func(accept string, sr *StandardResponse) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/items", nil)
+		r.Header.Set("Accept", accept)
+		SendFor(w, r, sr)
+		return w
+	}
+
+	w := send("text/csv", newList())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{ContentTypeCSV}, w.Header()["Content-Type"], "replaces the default content type")
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	assert.Equal(t, "id,name,created\n1,\"Ann, B\",2019-06-01T12:00:00Z\n2,Bob,0001-01-01T00:00:00Z\n", w.Body.String())
+
+	w = send("application/xml", newList())
+	assert.Equal(t, ContentTypeXML, w.Header().Get("Content-Type"))
+	assert.True(t, strings.Contains(w.Body.String(), "<response><message>ok</message><status>success</status><data>"), w.Body.String())
+
+	w = send("application/msgpack", newList())
+	assert.Equal(t, ContentTypeMsgpack, w.Header().Get("Content-Type"))
+	var decoded map[string]interface{}
+	assert.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &decoded))
+	assert.Equal(t, "ok", decoded["message"])
+
+	// map data cannot be represented as CSV
+	mapData := newList()
+	mapData.Data = map[string]string{"a": "b"}
+	w = send("text/csv", mapData)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	w = send("text/csv, application/xml;q=0.5", mapData)
+	assert.Equal(t, ContentTypeXML, w.Header().Get("Content-Type"))
+	assert.True(t, strings.Contains(w.Body.String(), "<data><a>b</a></data>"), w.Body.String())
+	w = send("text/csv, application/json;q=0.5", mapData)
+	assert.Equal(t, ContentTypeJSON, w.Header().Get("Content-Type"), "falls back to the next acceptable encoder")
+
+	w = send("image/png", newList())
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.NoError(t, SendFor(httptest.NewRecorder(), withAccept("image/png"), newList()), "the 406 is not an error")
+	assert.JSONEq(t, `{"message":"Not Acceptable","status":"client error"}`, w.Body.String())
+}
+
+func withAccept(accept string) *http.Request {
+	r := httptest.NewRequest("GET", "/items", nil)
+	r.Header.Set("Accept", accept)
+	return r
+}
+
+func TestXMLEncoderMaps(t *testing.T) {
+	var b strings.Builder
+	err := XMLEncoder{}.Encode(&b, &StandardBody{Status: "success", Data: []interface{}{
+		map[string]interface{}{"name": "Ada", "id": int64(1), "tags": []interface{}{"a", "b"}, "none": nil},
+		map[string]interface{}{"child": map[string]interface{}{"age": 4.5}},
+	}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, xml.Header+"<response><message></message><status>success</status>"+
+		"<data><id>1</id><name>Ada</name><tags>a</tags><tags>b</tags></data>"+
+		"<data><child><age>4.5</age></child></data></response>", b.String())
+
+	assert.Equal(t, ErrUnsupportedBody, XMLEncoder{}.Encode(&b, &StandardBody{Data: map[int]string{1: "a"}}))
+}
+
+func TestCSVEncoderMaps(t *testing.T) {
+	var b strings.Builder
+	err := CSVEncoder{}.Encode(&b, &StandardBody{Data: []map[string]interface{}{
+		{"id": 1, "tags": []string{"a"}},
+		{"id": 2, "name": "x"},
+	}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "id,name,tags\n1,,\"[\"\"a\"\"]\"\n2,x,\n", b.String())
+
+	assert.Equal(t, ErrUnsupportedBody, CSVEncoder{}.Encode(&b, &StandardBody{Data: "scalar"}))
+	assert.Equal(t, ErrUnsupportedBody, CSVEncoder{}.Encode(&b, &Problem{}))
+}
@@ -0,0 +1,211 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldsParam is the query parameter selecting the fields of the response data, e.g. "?fields=id,name,child.name"
+const FieldsParam = "fields"
+
+// ErrFieldNotAllowed describes a request selecting a field outside the allowlist
+// SendFor answers such requests with a 400 listing the disallowed fields, it does not return the error.
+var ErrFieldNotAllowed = errors.New("field selection not allowed")
+
+// AllowFields enables field selection with the fields query parameter for this response
+// Only the given dotted JSON paths, and the fields nested below them, may be selected; a request selecting
+// any other field is answered with a 400. Without paths, any field may be selected.
+// Responses that do not call AllowFields ignore the fields parameter.
+func (r *StandardResponse) AllowFields(paths ...string) {
+	r.fieldsEnabled = true
+	r.allowedFields = paths
+}
+
+// selectFields returns the data of the response pruned to the fields selected by a request
+// Data is converted through its JSON representation, so fields are named after their JSON tags,
+// and slices have the selection applied to each element. Objects become maps, which the XMLEncoder
+// renders as an element per key.
+func (r *StandardResponse) selectFields(req *http.Request) (interface{}, error) {
+	if !r.fieldsEnabled || req == nil || r.Data == nil {
+		return r.Data, nil
+	}
+
+	paths := parseFields(req.URL.Query()[FieldsParam])
+	if len(paths) == 0 {
+		return r.Data, nil
+	}
+
+	if disallowed := r.disallowedFields(paths); len(disallowed) > 0 {
+		return nil, fieldsError(disallowed)
+	}
+
+	b, err := json.Marshal(r.Data)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return convertNumbers(newFieldTree(paths).prune(data)), nil
+}
+
+// convertNumbers replaces the json.Number values of decoded data with an int64, a uint64 beyond its range,
+// or a float64, so that encoders other than JSON do not see them as strings
+func convertNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			v[key] = convertNumbers(field)
+		}
+	case []interface{}:
+		for i, element := range v {
+			v[i] = convertNumbers(element)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+
+	return value
+}
+
+// disallowedFields returns the selected paths outside the allowlist
+func (r *StandardResponse) disallowedFields(paths []string) []string {
+	if len(r.allowedFields) == 0 {
+		return nil
+	}
+
+	var disallowed []string
+	for _, path := range paths {
+		allowed := false
+		for _, a := range r.allowedFields {
+			if path == a || strings.HasPrefix(path, a+".") {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			disallowed = append(disallowed, path)
+		}
+	}
+
+	return disallowed
+}
+
+// parseFields splits the values of the fields parameter into dotted paths
+func parseFields(values []string) []string {
+	var paths []string
+	for _, value := range values {
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.Trim(strings.TrimSpace(path), "."); path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// fieldTree is a set of selected paths, an empty subtree selecting the whole value
+type fieldTree map[string]fieldTree
+
+func newFieldTree(paths []string) fieldTree {
+	tree := fieldTree{}
+	for _, path := range paths {
+		node := tree
+		segments := strings.Split(path, ".")
+		for i, segment := range segments {
+			child, ok := node[segment]
+			if ok && len(child) == 0 {
+				// the whole value is already selected
+				break
+			}
+			if i == len(segments)-1 {
+				node[segment] = fieldTree{}
+				break
+			}
+			if !ok {
+				child = fieldTree{}
+				node[segment] = child
+			}
+			node = child
+		}
+	}
+	return tree
+}
+
+// prune keeps the selected fields of objects, applying the selection to each element of arrays
+func (t fieldTree) prune(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		pruned := make(map[string]interface{}, len(t))
+		for key, subtree := range t {
+			field, ok := v[key]
+			if !ok {
+				continue
+			}
+			if len(subtree) > 0 {
+				field = subtree.prune(field)
+			}
+			pruned[key] = field
+		}
+		return pruned
+	case []interface{}:
+		pruned := make([]interface{}, len(v))
+		for i, element := range v {
+			pruned[i] = t.prune(element)
+		}
+		return pruned
+	}
+
+	return value
+}
+
+// fieldsFailure reports a selected field outside the allowlist
+type fieldsFailure struct {
+	path    string
+	allowed []string
+}
+
+func (f fieldsFailure) Field() string      { return FieldsParam }
+func (f fieldsFailure) Tag() string        { return "oneof" }
+func (f fieldsFailure) Value() interface{} { return f.path }
+func (f fieldsFailure) Param() string      { return strings.Join(f.allowed, " ") }
+
+// fieldsError carries the selected fields outside the allowlist
+type fieldsError []string
+
+func (e fieldsError) Error() string {
+	return ErrFieldNotAllowed.Error() + ": " + strings.Join(e, ", ")
+}
+
+// sendFieldsNotAllowed answers a request selecting fields outside the allowlist
+func sendFieldsNotAllowed(w http.ResponseWriter, r *http.Request, sr *StandardResponse, disallowed fieldsError) error {
+	allowed := append([]string(nil), sr.allowedFields...)
+	sort.Strings(allowed)
+
+	res := New()
+	res.StatusCode = http.StatusBadRequest
+	res.Message = "Invalid fields parameter"
+	for _, path := range disallowed {
+		res.AddValidationFailure(fieldsFailure{path: path, allowed: allowed})
+	}
+
+	// the 400 is the answer, the caller has nothing left to report
+	return SendFor(w, r, res)
+}
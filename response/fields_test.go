@@ -0,0 +1,137 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+type fieldsChild struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+type fieldsUser struct {
+	ID       int           `json:"id"`
+	Name     string        `json:"name"`
+	Email    string        `json:"email"`
+	Password string        `json:"-"`
+	Children []fieldsChild `json:"children"`
+	Meta     map[string]interface{}
+}
+
+func sendFields(t *testing.T, query string, allowed []string, data interface{}) *httptest.ResponseRecorder {
+	sr := New()
+	sr.StatusCode = http.StatusOK
+	sr.Data = data
+	if allowed != nil {
+		sr.AllowFields(allowed...)
+	}
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, SendFor(w, httptest.NewRequest("GET", "/users?"+query, nil), sr), "answered responses are not errors")
+	return w
+}
+
+func TestSelectFields(t *testing.T) {
+	user := fieldsUser{
+		ID:       1,
+		Name:     "Ada",
+		Email:    "ada@example.com",
+		Children: []fieldsChild{{"Bo", 4}, {"Cy", 5}},
+		Meta:     map[string]interface{}{"plan": "pro", "seats": 12},
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		allowed  []string
+		data     interface{}
+		expected string
+	}{
+		{"disabled", "fields=id", nil, map[string]int{"id": 1, "age": 2}, `{"age":2,"id":1}`},
+		{"no selection", "", []string{}, map[string]int{"id": 1}, `{"id":1}`},
+		{"struct", "fields=id,name", []string{}, user, `{"id":1,"name":"Ada"}`},
+		{"nested", "fields=id&fields=children.name,Meta.plan", []string{}, user, `{"Meta":{"plan":"pro"},"children":[{"name":"Bo"},{"name":"Cy"}],"id":1}`},
+		{"whole nested", "fields=children.name,children", []string{}, user, `{"children":[{"name":"Bo","age":4},{"name":"Cy","age":5}]}`},
+		{"slice", "fields=name", []string{}, []fieldsUser{user, user}, `[{"name":"Ada"},{"name":"Ada"}]`},
+		{"missing", "fields=password,nope", []string{}, user, `{}`},
+		{"allowed", "fields=id,children.age", []string{"id", "children"}, user, `{"children":[{"age":4},{"age":5}],"id":1}`},
+	}
+
+	for _, test := range tests {
+		w := sendFields(t, test.query, test.allowed, test.data)
+		assert.Equal(t, http.StatusOK, w.Code, test.name)
+
+		var body struct {
+			Data json.RawMessage `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), test.name)
+		assert.JSONEq(t, test.expected, string(body.Data), test.name)
+	}
+}
+
+func TestSelectFieldsNotAllowed(t *testing.T) {
+	w := sendFields(t, "fields=id,email,children.name", []string{"id", "children.name", "name"}, fieldsUser{})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var body StandardBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "Invalid fields parameter", body.Message)
+	assert.Nil(t, body.Data)
+	assert.Equal(t, ValidationResponses{
+		{Field: "fields", Tag: "oneof", Value: "email", Allowed: "children.name id name"},
+	}, *body.Validations)
+}
+
+func TestSelectFieldsEncoders(t *testing.T) {
+	user := fieldsUser{ID: 1, Name: "Ada", Children: []fieldsChild{{"Bo", 4}}, Meta: map[string]interface{}{"seats": 12, "ratio": 0.5}}
+
+	send := func(accept string) *httptest.ResponseRecorder {
+		sr := New()
+		sr.StatusCode = http.StatusOK
+		sr.Data = user
+		sr.AllowFields()
+
+		r := httptest.NewRequest("GET", "/users?fields=id,children.age,Meta", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		SendFor(w, r, sr)
+		return w
+	}
+
+	w := send(ContentTypeMsgpack)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Data map[string]interface{} `msgpack:"data"`
+	}
+	assert.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, int64(1), body.Data["id"], "numbers are not encoded as strings")
+	assert.Equal(t, []interface{}{map[string]interface{}{"age": int64(4)}}, body.Data["children"])
+	assert.Equal(t, map[string]interface{}{"seats": int64(12), "ratio": 0.5}, body.Data["Meta"])
+
+	w = send(ContentTypeXML)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<data><Meta><ratio>0.5</ratio><seats>12</seats></Meta><children><age>4</age></children><id>1</id></data>")
+}
+
+func TestConvertNumbers(t *testing.T) {
+	data := map[string]interface{}{
+		"int":   json.Number("-3"),
+		"big":   json.Number("18446744073709551615"),
+		"float": json.Number("1.5e3"),
+		"list":  []interface{}{json.Number("7"), "7"},
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"int":   int64(-3),
+		"big":   uint64(18446744073709551615),
+		"float": 1500.0,
+		"list":  []interface{}{int64(7), "7"},
+	}, convertNumbers(data))
+}
@@ -0,0 +1,224 @@
+package response
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	"github.com/go-chi/chi"
+)
+
+// Links are the hypermedia links of a response, following the JSON:API links object
+type Links struct {
+	Self  string `json:"self,omitempty" xml:"self,omitempty"`
+	First string `json:"first,omitempty" xml:"first,omitempty"`
+	Prev  string `json:"prev,omitempty" xml:"prev,omitempty"`
+	Next  string `json:"next,omitempty" xml:"next,omitempty"`
+	Last  string `json:"last,omitempty" xml:"last,omitempty"`
+
+	// Related links to related resources by name, e.g. "teacher"
+	Related map[string]string `json:"related,omitempty" xml:"-"`
+}
+
+// EnableLinks adds links to the response body, populated by SendFor from the request and the pagination
+func (r *StandardResponse) EnableLinks() {
+	if r.links == nil {
+		r.links = &Links{}
+	}
+}
+
+// SetLinks sets the links of the response body
+// Empty links are still populated by SendFor from the request and the pagination.
+func (r *StandardResponse) SetLinks(l *Links) {
+	r.links = l
+}
+
+// AddRelatedLink adds a link to a related resource, enabling links
+// An absolute path is prefixed with the locale of the request when sent with SendFor, see BuildLinks.
+func (r *StandardResponse) AddRelatedLink(name string, href string) {
+	r.EnableLinks()
+	if r.links.Related == nil {
+		r.links.Related = map[string]string{}
+	}
+	r.links.Related[name] = href
+}
+
+// BuildLinks builds the links of the response for a request, or returns nil when links are not enabled
+// Self is the request URL. Prev and next come from the pagination, as do first and last for offset pagination.
+// Absolute paths are resolved against the host and scheme the client used, taken from the Forwarded or
+// X-Forwarded-Host/X-Forwarded-Proto headers when behind a trusted proxy, see BaseURL. Within middleware.AddLocale routes,
+// set paths missing the locale of the request get it inserted where the request path has it,
+// e.g. "/planning/teachers/3" becomes "/planning/es-us/teachers/3".
+// Without a request, the links are returned as set.
+func (r *StandardResponse) BuildLinks(req *http.Request) *Links {
+	if r.links == nil {
+		return nil
+	}
+
+	links := *r.links
+	if req == nil {
+		return &links
+	}
+
+	links.Self = localizeLink(req, links.Self)
+	links.First = localizeLink(req, links.First)
+	links.Prev = localizeLink(req, links.Prev)
+	links.Next = localizeLink(req, links.Next)
+	links.Last = localizeLink(req, links.Last)
+
+	if links.Self == "" {
+		links.Self = req.URL.RequestURI()
+	}
+
+	if p := r.pagination; p != nil {
+		if links.Prev == "" {
+			links.Prev = p.Prev
+		}
+		if links.Next == "" {
+			links.Next = p.Next
+		}
+
+		// offset pagination, where the total is known
+		if p.Limit > 0 && p.TotalRecords >= 0 && p.NextCursor == "" && p.PrevCursor == "" {
+			if links.First == "" {
+				links.First = offsetLink(req, 0)
+			}
+			if links.Last == "" {
+				last := 0
+				if p.TotalRecords > 0 {
+					last = (p.TotalRecords - 1) / p.Limit * p.Limit
+				}
+				links.Last = offsetLink(req, last)
+			}
+		}
+	}
+
+	base := BaseURL(req)
+	links.Self = absoluteLink(base, links.Self)
+	links.First = absoluteLink(base, links.First)
+	links.Prev = absoluteLink(base, links.Prev)
+	links.Next = absoluteLink(base, links.Next)
+	links.Last = absoluteLink(base, links.Last)
+
+	if len(links.Related) > 0 {
+		related := make(map[string]string, len(links.Related))
+		for name, href := range links.Related {
+			related[name] = absoluteLink(base, localizeLink(req, href))
+		}
+		links.Related = related
+	}
+
+	return &links
+}
+
+// trustedProxies holds the []*net.IPNet set by SetTrustedProxies
+var trustedProxies atomic.Value
+
+// SetTrustedProxies sets the IPs or CIDR ranges of the proxies whose Forwarded and X-Forwarded-*
+// headers are trusted by BaseURL, replacing the previous ones
+// Without trusted proxies, the default, these headers are ignored, as any client can set them.
+func SetTrustedProxies(proxies ...string) {
+	trustedProxies.Store(utils.ParseTrustedProxies(proxies))
+}
+
+// BaseURL returns the scheme and host the client used to make a request, e.g. "https://api.example.com"
+// When the request comes from a trusted proxy, see SetTrustedProxies, the Forwarded header takes
+// precedence over X-Forwarded-Proto and X-Forwarded-Host, which take precedence over the request itself.
+func BaseURL(req *http.Request) string {
+	var proto, host string
+
+	if trusted, _ := trustedProxies.Load().([]*net.IPNet); utils.IsTrustedRemote(req.RemoteAddr, trusted) {
+		proto, host = forwarded(req.Header.Get("Forwarded"))
+
+		if proto == "" {
+			proto = firstValue(req.Header.Get("X-Forwarded-Proto"))
+		}
+		if host == "" {
+			host = firstValue(req.Header.Get("X-Forwarded-Host"))
+		}
+	}
+
+	if proto == "" {
+		proto = "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+	}
+	if host == "" {
+		host = req.Host
+	}
+
+	return strings.ToLower(proto) + "://" + host
+}
+
+// forwarded returns the proto and host of the first element of an RFC 7239 Forwarded header
+func forwarded(header string) (string, string) {
+	var proto, host string
+	for _, pair := range strings.Split(firstValue(header), ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.Trim(kv[1], `"`)
+		switch strings.ToLower(kv[0]) {
+		case "proto":
+			proto = value
+		case "host":
+			host = value
+		}
+	}
+	return proto, host
+}
+
+// firstValue returns the first value of a comma separated header, i.e. the one set by the proxy closest to the client
+func firstValue(header string) string {
+	return strings.TrimSpace(strings.SplitN(header, ",", 2)[0])
+}
+
+// offsetLink returns the request path and query with the offset replaced
+func offsetLink(req *http.Request, offset int) string {
+	query := req.URL.Query()
+	query.Set("offset", strconv.Itoa(offset))
+	return req.URL.Path + "?" + query.Encode()
+}
+
+// absoluteLink resolves an absolute path against a base URL, leaving other links untouched
+func absoluteLink(base string, href string) string {
+	if !strings.HasPrefix(href, "/") || strings.HasPrefix(href, "//") {
+		return href
+	}
+	return base + href
+}
+
+// localizeLink inserts the locale of a request routed through middleware.AddLocale into an absolute path
+// The locale goes after the same prefix as in the request path; paths outside that prefix or already
+// carrying the locale are left untouched.
+func localizeLink(req *http.Request, href string) string {
+	rctx, ok := req.Context().Value(chi.RouteCtxKey).(*chi.Context)
+	if !ok {
+		return href
+	}
+
+	locale := rctx.URLParam("locale")
+	if locale == "" || !strings.HasPrefix(href, "/") || strings.HasPrefix(href, "//") {
+		return href
+	}
+
+	path := req.URL.Path + "/"
+	i := strings.Index(path, "/"+locale+"/")
+	if i < 0 {
+		return href
+	}
+	prefix := path[:i]
+
+	if !strings.HasPrefix(href, prefix+"/") ||
+		strings.HasPrefix(href+"/", prefix+"/"+locale+"/") ||
+		strings.HasPrefix(href, prefix+"/"+locale+"?") {
+		return href
+	}
+
+	return prefix + "/" + locale + href[len(prefix):]
+}
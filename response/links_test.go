@@ -0,0 +1,107 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildLinksPagination(t *testing.T) {
+	sr := New()
+	sr.StatusCode = http.StatusOK
+	sr.EnableLinks()
+	sr.SetPagination(&Pagination{Offset: 20, Limit: 10, TotalRecords: 45, Next: "/events?limit=10&offset=30", Prev: "/events?limit=10&offset=10"})
+
+	r := httptest.NewRequest("GET", "/events?limit=10&offset=20", nil)
+	assert.Equal(t, &Links{
+		Self:  "http://example.com/events?limit=10&offset=20",
+		First: "http://example.com/events?limit=10&offset=0",
+		Prev:  "http://example.com/events?limit=10&offset=10",
+		Next:  "http://example.com/events?limit=10&offset=30",
+		Last:  "http://example.com/events?limit=10&offset=40",
+	}, sr.BuildLinks(r))
+
+	// keyset pagination has no first and last pages
+	sr.SetPagination(&Pagination{Limit: 10, TotalRecords: -1, NextCursor: "abc", Next: "/events?cursor=abc"})
+	assert.Equal(t, &Links{
+		Self: "http://example.com/events?limit=10&offset=20",
+		Next: "http://example.com/events?cursor=abc",
+	}, sr.BuildLinks(r))
+
+	assert.Nil(t, New().BuildLinks(r), "links are optional")
+}
+
+func TestBaseURL(t *testing.T) {
+	// httptest requests come from 192.0.2.1
+	SetTrustedProxies("10.0.0.0/8", "192.0.2.1")
+	defer SetTrustedProxies()
+
+	tests := []struct {
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"192.0.2.1:1234", map[string]string{}, "http://example.com"},
+		{"192.0.2.1:1234", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.example.com, proxy.internal"}, "https://api.example.com"},
+		{"10.1.2.3:1234", map[string]string{"Forwarded": `for=1.2.3.4;proto=https;host="edge.example.com", for=10.0.0.1`, "X-Forwarded-Host": "ignored.com"}, "https://edge.example.com"},
+		{"203.0.113.7:1234", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.com"}, "http://example.com"},
+		{"203.0.113.7:1234", map[string]string{"Forwarded": `proto=https;host=evil.com`}, "http://example.com"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/events", nil)
+		r.RemoteAddr = test.remoteAddr
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+		assert.Equal(t, test.expected, BaseURL(r), test.remoteAddr)
+	}
+
+	SetTrustedProxies()
+	r := httptest.NewRequest("GET", "/events", nil)
+	r.Header.Set("X-Forwarded-Host", "api.example.com")
+	assert.Equal(t, "http://example.com", BaseURL(r), "no trusted proxies")
+}
+
+func TestSendForLinksLocale(t *testing.T) {
+	SetTrustedProxies("192.0.2.1")
+	defer SetTrustedProxies()
+
+	router := chi.NewRouter()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		sr := New()
+		sr.StatusCode = http.StatusOK
+		sr.AddRelatedLink("teacher", "/planning/teachers/3")
+		sr.AddRelatedLink("docs", "https://docs.example.com/events")
+		SendFor(w, r, sr)
+	}
+	router.Get("/planning/{locale}/events/{id}", handler)
+	router.Get("/planning/events/{id}", handler)
+
+	tests := []struct {
+		path    string
+		self    string
+		teacher string
+	}{
+		{"/planning/es-us/events/1", "https://api.example.com/planning/es-us/events/1", "https://api.example.com/planning/es-us/teachers/3"},
+		{"/planning/events/1", "https://api.example.com/planning/events/1", "https://api.example.com/planning/teachers/3"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "api.example.com")
+		router.ServeHTTP(w, r)
+
+		var body StandardBody
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, test.self, body.Links.Self)
+		assert.Equal(t, test.teacher, body.Links.Related["teacher"])
+		assert.Equal(t, "https://docs.example.com/events", body.Links.Related["docs"])
+	}
+}
@@ -0,0 +1,145 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	// ContentTypeJSON is the media type of StandardBody responses
+	ContentTypeJSON = "application/json"
+	// ContentTypeProblemJSON is the RFC 7807 problem details media type
+	ContentTypeProblemJSON = "application/problem+json"
+
+	// DefaultProblemType is the RFC 7807 type of problems without a more specific one
+	DefaultProblemType = "about:blank"
+)
+
+// ErrorFormat selects how error responses (status 400 and above) are rendered
+type ErrorFormat int32
+
+const (
+	// StandardBodyFormat renders errors with the StandardBody envelope
+	StandardBodyFormat ErrorFormat = iota
+	// ProblemJSONFormat renders errors as RFC 7807 problem details
+	ProblemJSONFormat
+)
+
+// errorFormat is the process wide error format, see SetErrorFormat
+var errorFormat int32
+
+// SetErrorFormat configures how error responses are rendered by Send and SendFor
+// With StandardBodyFormat, the default, clients can still ask for problem details
+// through the Accept header when the response is sent with SendFor.
+func SetErrorFormat(f ErrorFormat) {
+	atomic.StoreInt32(&errorFormat, int32(f))
+}
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code is an extension member carrying the machine readable error code
+	Code string `json:"code,omitempty"`
+
+	// Validations is an extension member carrying the validation failures
+	Validations *ValidationResponses `json:"validations,omitempty"`
+	// Extensions are additional members serialized alongside the standard ones
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON serializes the problem with its extension members at the top level
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	b, err := json.Marshal(problem(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return b, err
+	}
+
+	members := map[string]interface{}{}
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	// standard members win over extensions of the same name
+	if err := json.Unmarshal(b, &members); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(members)
+}
+
+// SetProblemType sets the URI identifying the problem type of an error response
+func (r *StandardResponse) SetProblemType(uri string) {
+	r.problemType = uri
+}
+
+// SetInstance sets the URI identifying this occurrence of the problem, usually the request path
+func (r *StandardResponse) SetInstance(uri string) {
+	r.instance = uri
+}
+
+// AddProblemExtension adds an extension member to the problem details of an error response
+func (r *StandardResponse) AddProblemExtension(key string, value interface{}) {
+	if r.problemExtensions == nil {
+		r.problemExtensions = map[string]interface{}{}
+	}
+	r.problemExtensions[key] = value
+}
+
+// BuildProblem builds the RFC 7807 problem details of the response
+func (r *StandardResponse) BuildProblem() *Problem {
+	problem := &Problem{
+		Type:       r.problemType,
+		Title:      http.StatusText(r.StatusCode),
+		Status:     r.StatusCode,
+		Detail:     r.translatedMessage(),
+		Instance:   r.instance,
+		Code:       r.Code,
+		Extensions: r.problemExtensions,
+	}
+	if problem.Type == "" {
+		problem.Type = DefaultProblemType
+	}
+
+	validations := r.validationResponses()
+	if len(*validations) > 0 {
+		problem.Validations = validations
+	}
+
+	return problem
+}
+
+// IsError reports whether the response is an error, i.e. its status is 400 or above
+func (r *StandardResponse) IsError() bool {
+	return r.StatusCode >= http.StatusBadRequest
+}
+
+// useProblem reports whether an error response is rendered as problem details,
+// either because it is configured or because the request accepts them
+func useProblem(r *http.Request, sr *StandardResponse) bool {
+	if !sr.IsError() {
+		return false
+	}
+	if ErrorFormat(atomic.LoadInt32(&errorFormat)) == ProblemJSONFormat {
+		return true
+	}
+	return r != nil && acceptsProblem(r.Header.Get("Accept"))
+}
+
+// acceptsProblem reports whether an Accept header prefers problem details over plain JSON
+func acceptsProblem(accept string) bool {
+	problemQ, jsonQ := -1.0, -1.0
+	for _, mr := range parseAccept(accept) {
+		switch mr.mediaType {
+		case ContentTypeProblemJSON:
+			problemQ = mr.q
+		case ContentTypeJSON:
+			jsonQ = mr.q
+		}
+	}
+	return problemQ > 0 && problemQ >= jsonQ
+}
@@ -0,0 +1,97 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandardResponseBuildProblem(t *testing.T) {
+	res := New()
+	res.StatusCode = http.StatusUnprocessableEntity
+	res.Message = "Invalid planning event"
+	res.SetInstance("/planning/events/12")
+	res.AddValidationFailure(validationFailure{1})
+
+	assert.Equal(t, &Problem{
+		Type:     DefaultProblemType,
+		Title:    "Unprocessable Entity",
+		Status:   http.StatusUnprocessableEntity,
+		Detail:   "Invalid planning event",
+		Instance: "/planning/events/12",
+		Validations: &ValidationResponses{
+			ValidationFailureResponse{Field: "Field: 1", Tag: "Tag: 1", Value: "Value: 1", Allowed: "Param: 1"},
+		},
+	}, res.BuildProblem())
+}
+
+func TestProblemMarshalJSON(t *testing.T) {
+	problem := Problem{
+		Type:       "https://example.com/probs/out-of-credit",
+		Title:      "Forbidden",
+		Status:     403,
+		Extensions: map[string]interface{}{"balance": 30, "status": "ignored"},
+	}
+
+	b, err := json.Marshal(problem)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "https://example.com/probs/out-of-credit",
+		"title": "Forbidden",
+		"status": 403,
+		"balance": 30
+	}`, string(b))
+}
+
+func TestAcceptsProblem(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "*/*", want: false},
+		{accept: "application/json", want: false},
+		{accept: "application/problem+json", want: true},
+		{accept: "application/json;q=0.5, application/problem+json", want: true},
+		{accept: "application/json, application/problem+json;q=0.9", want: false},
+		{accept: "application/problem+json;q=0", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, acceptsProblem(tt.accept), tt.accept)
+	}
+}
+
+func TestSendForProblem(t *testing.T) {
+	newError := func() *StandardResponse {
+		res := New()
+		res.StatusCode = http.StatusNotFound
+		res.Message = "Event not found"
+		return res
+	}
+
+	// client asks for problem details
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/events/1", nil)
+	r.Header.Set("Accept", ContentTypeProblemJSON)
+	assert.NoError(t, SendFor(w, r, newError()))
+	assert.Equal(t, []string{ContentTypeProblemJSON}, w.Header()["Content-Type"])
+	assert.JSONEq(t, `{"type":"about:blank","title":"Not Found","status":404,"detail":"Event not found"}`, w.Body.String())
+
+	// successful responses keep the standard body
+	w = httptest.NewRecorder()
+	ok := New()
+	ok.StatusCode = http.StatusOK
+	assert.NoError(t, SendFor(w, r, ok))
+	assert.Equal(t, ContentTypeJSON, w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"message":"","status":"success"}`, w.Body.String())
+
+	// configured globally
+	SetErrorFormat(ProblemJSONFormat)
+	defer SetErrorFormat(StandardBodyFormat)
+	w = httptest.NewRecorder()
+	assert.NoError(t, Send(w, newError()))
+	assert.Equal(t, ContentTypeProblemJSON, w.Header().Get("Content-Type"))
+}
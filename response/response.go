@@ -3,6 +3,7 @@ package response
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"gopkg.in/go-playground/validator.v9"
 )
@@ -16,19 +17,28 @@ const (
 
 // StandardBody is the standard format for REST/JSON response bodies
 type StandardBody struct {
-	Message string `json:"message"`
-	Status  string `json:"status"`
+	Message string `json:"message" xml:"message"`
+	Status  string `json:"status" xml:"status"`
+	Code    string `json:"code,omitempty" xml:"code,omitempty"`
 
-	Data        interface{}          `json:"data,omitempty"`
-	Pagination  *Pagination          `json:"pagination,omitempty"`
-	Validations *ValidationResponses `json:"validations,omitempty"`
+	Data        interface{}          `json:"data,omitempty" xml:"data,omitempty"`
+	Pagination  *Pagination          `json:"pagination,omitempty" xml:"pagination,omitempty"`
+	Links       *Links               `json:"links,omitempty" xml:"links,omitempty"`
+	Validations *ValidationResponses `json:"validations,omitempty" xml:"validations>validation,omitempty"`
 }
 
 // Pagination contains parameters useful for paging through a subset
 type Pagination struct {
-	Offset       int `json:"offset"`
-	Limit        int `json:"limit"`
-	TotalRecords int `json:"totalRecords"`
+	Offset       int `json:"offset" xml:"offset"`
+	Limit        int `json:"limit" xml:"limit"`
+	TotalRecords int `json:"totalRecords" xml:"totalRecords"`
+
+	// NextCursor and PrevCursor are opaque keyset pagination cursors
+	NextCursor string `json:"nextCursor,omitempty" xml:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty" xml:"prevCursor,omitempty"`
+	// Next and Prev are links to the adjacent pages
+	Next string `json:"next,omitempty" xml:"next,omitempty"`
+	Prev string `json:"prev,omitempty" xml:"prev,omitempty"`
 }
 
 // ValidationResponses represents a collection of validation failure responses
@@ -36,10 +46,12 @@ type ValidationResponses []ValidationFailureResponse
 
 // ValidationFailureResponse contains individual validation failure metadata
 type ValidationFailureResponse struct {
-	Field   string      `json:"field"`
-	Tag     string      `json:"tag"`
-	Value   interface{} `json:"value"`
-	Allowed interface{} `json:"allowed"`
+	Field   string      `json:"field" xml:"field"`
+	Tag     string      `json:"tag" xml:"tag"`
+	Value   interface{} `json:"value" xml:"value"`
+	Allowed interface{} `json:"allowed" xml:"allowed"`
+	// Message is the human readable description of the failure, set when the response is translated
+	Message string `json:"message,omitempty" xml:"message,omitempty"`
 }
 
 // ValidationFailure provides an interface for validation failures based upon gopkg.in/go-playground/validator.v9
@@ -55,20 +67,37 @@ type StandardResponse struct {
 	StatusCode int
 	Message    string
 	Data       interface{}
+	// Code is an optional machine readable error code, e.g. "user_not_found"
+	Code string
 
 	pagination         *Pagination
 	validationFailures []ValidationFailure
 	headers            map[string][]string
+
+	problemType       string
+	instance          string
+	problemExtensions map[string]interface{}
+
+	lastModified time.Time
+
+	translator Translator
+
+	links *Links
+
+	fieldsEnabled bool
+	allowedFields []string
 }
 
 // New creates a standard response with defaults
 func New() *StandardResponse {
 	res := &StandardResponse{}
-	res.AddHeader("Content-Type", "application/json")
+	res.AddHeader("Content-Type", ContentTypeJSON)
 	return res
 }
 
 // NewWithCors creates a standard response with defaults and cors headers
+//
+// Deprecated: use the middleware.Cors middleware, which sets the CORS headers of every response.
 func NewWithCors(origin string) *StandardResponse {
 	res := New()
 	res.AddCorsHeaders(origin)
@@ -76,6 +105,8 @@ func NewWithCors(origin string) *StandardResponse {
 }
 
 // AddCorsHeaders adds common CORS HTTP headers
+//
+// Deprecated: use the middleware.Cors middleware, which sets the CORS headers of every response.
 func (r *StandardResponse) AddCorsHeaders(origin string) {
 	r.AddHeader("Access-Control-Allow-Origin", origin)
 	r.AddHeader("Access-Control-Allow-Methods", CorsAllowedMethods)
@@ -144,16 +175,18 @@ func (r *StandardResponse) SetPagination(p *Pagination) {
 func (r *StandardResponse) BuildBody() *StandardBody {
 	body := &StandardBody{
 		Status:  getStatus(r.StatusCode),
-		Message: r.Message,
+		Message: r.translatedMessage(),
+		Code:    r.Code,
 		Data:    r.Data,
 	}
-	validations := prepareValidationResponses(r.validationFailures)
+	validations := r.validationResponses()
 	if len(*validations) > 0 {
 		body.Validations = validations
 	}
 	if r.pagination != nil {
 		body.Pagination = r.pagination
 	}
+	body.Links = r.BuildLinks(nil)
 	return body
 }
 
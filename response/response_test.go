@@ -269,12 +269,14 @@ func TestStandardResponseBuildBody(t *testing.T) {
 			"Tag: 1",
 			"Value: 1",
 			"Param: 1",
+			"",
 		},
 		ValidationFailureResponse{
 			"Field: 2",
 			"Tag: 2",
 			"Value: 2",
 			"Param: 2",
+			"",
 		},
 	}
 
@@ -290,6 +292,7 @@ func TestStandardResponseBuildBody(t *testing.T) {
 			"Tag: 3",
 			"Value: 3",
 			"Param: 3",
+			"",
 		},
 	}
 
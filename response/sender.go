@@ -1,16 +1,57 @@
 package response
 
 import (
-	"encoding/json"
 	"errors"
 	"net/http"
 )
 
-// Send writes a StandardResponse to the given ResponseWriter
+// Send writes a StandardResponse to the given ResponseWriter as JSON
+// Error responses are rendered as problem details when configured with SetErrorFormat.
 func Send(w http.ResponseWriter, sr *StandardResponse) error {
-	body := sr.BuildBody()
+	return SendFor(w, nil, sr)
+}
+
+// SendFor writes a StandardResponse to the given ResponseWriter, negotiating its format with the request
+// The body is encoded with the registered encoder preferred by the request Accept header, see RegisterEncoder,
+// and a 406 is sent when none is acceptable, which is not reported as an error.
+// Error responses are rendered as problem details when configured with SetErrorFormat,
+// or when the request Accept header prefers application/problem+json.
+// Successful GET and HEAD responses carry a strong ETag of the encoded body, and a 304 Not Modified
+// is sent instead when the request If-None-Match or If-Modified-Since headers show it is unchanged.
+// Links, when enabled, are populated from the request, see BuildLinks.
+// Data is pruned to the fields selected by the fields query parameter when enabled, see AllowFields.
+func SendFor(w http.ResponseWriter, r *http.Request, sr *StandardResponse) error {
+	var body interface{}
+	var candidates []Encoder
+	negotiated := r != nil
 
-	b, err := json.Marshal(body)
+	switch {
+	case useProblem(r, sr):
+		body = sr.BuildProblem()
+		candidates = []Encoder{problemEncoder{}}
+		negotiated = true
+	case r == nil:
+		body = sr.BuildBody()
+		candidates = []Encoder{JSONEncoder{}}
+	default:
+		sb := sr.BuildBody()
+		sb.Links = sr.BuildLinks(r)
+		data, err := sr.selectFields(r)
+		if disallowed, ok := err.(fieldsError); ok {
+			return sendFieldsNotAllowed(w, r, sr, disallowed)
+		}
+		if err != nil {
+			return err
+		}
+		sb.Data = data
+		body = sb
+		candidates = negotiate(r.Header.Get("Accept"))
+	}
+
+	b, contentType, err := encode(candidates, body)
+	if err == ErrNotAcceptable {
+		return sendNotAcceptable(w)
+	}
 	if err != nil {
 		return err
 	}
@@ -21,10 +62,25 @@ func Send(w http.ResponseWriter, sr *StandardResponse) error {
 		}
 	}
 
+	if negotiated {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Add("Vary", "Accept")
+	}
+
 	if sr.StatusCode == 0 {
 		return errors.New("status code must be set")
 	}
 
+	if isCacheable(r, sr) {
+		etag := ETag(b)
+		w.Header().Set("ETag", etag)
+
+		if notModified(r, etag, sr.lastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
 	w.WriteHeader(sr.StatusCode)
 
 	_, err = w.Write(b)
@@ -34,3 +90,15 @@ func Send(w http.ResponseWriter, sr *StandardResponse) error {
 
 	return nil
 }
+
+// sendNotAcceptable answers a request whose Accept header matches no encoder
+func sendNotAcceptable(w http.ResponseWriter) error {
+	res := New()
+	res.StatusCode = http.StatusNotAcceptable
+	res.Message = http.StatusText(http.StatusNotAcceptable)
+
+	w.Header().Add("Vary", "Accept")
+
+	// the 406 is the answer, the caller has nothing left to report
+	return Send(w, res)
+}
@@ -0,0 +1,212 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const (
+	// ContentTypeNDJSON is the media type of newline delimited JSON streams
+	ContentTypeNDJSON = "application/x-ndjson"
+
+	defaultFlushEvery = 100
+)
+
+// streamInterrupted is written at the end of a stream whose iterator failed,
+// as the status has already been sent
+const streamInterrupted = `"error":"stream interrupted"`
+
+// Iterator yields the items of a streamed response
+type Iterator interface {
+	// Next returns the next item, ok is false once there are no more items
+	Next() (item interface{}, ok bool, err error)
+}
+
+// IteratorFunc adapts a function to the Iterator interface
+type IteratorFunc func() (interface{}, bool, error)
+
+// Next implements Iterator
+func (f IteratorFunc) Next() (interface{}, bool, error) {
+	return f()
+}
+
+// ChannelIterator returns an Iterator over the items received from ch until it is closed
+func ChannelIterator(ch <-chan interface{}) Iterator {
+	return IteratorFunc(func() (interface{}, bool, error) {
+		item, ok := <-ch
+		return item, ok, nil
+	})
+}
+
+// StreamOptions configures Stream
+type StreamOptions struct {
+	// NDJSON writes one JSON item per line instead of the StandardBody envelope
+	NDJSON bool
+	// FlushEvery is the number of items written between flushes (default 100)
+	FlushEvery int
+}
+
+// Stream writes a StandardResponse whose data is a list pulled from items, without holding it in memory
+// The StandardBody envelope is written incrementally: message and status first, then the items of data
+// as a JSON array, then the pagination and links, which may therefore be set on sr while iterating.
+// In NDJSON mode only the items are written, one per line.
+//
+// Headers and status are only written once the first item is available, so an error from the first
+// call to Next is returned with nothing written. A later error ends the stream with an `error` member
+// (or line, in NDJSON mode) and is returned.
+func Stream(w http.ResponseWriter, sr *StandardResponse, items Iterator, opts *StreamOptions) error {
+	return stream(w, nil, sr, items, opts)
+}
+
+func stream(w http.ResponseWriter, r *http.Request, sr *StandardResponse, items Iterator, opts *StreamOptions) error {
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+	flushEvery := opts.FlushEvery
+	if flushEvery < 1 {
+		flushEvery = defaultFlushEvery
+	}
+
+	if sr.StatusCode == 0 {
+		return errors.New("status code must be set")
+	}
+
+	item, ok, err := items.Next()
+	if err != nil {
+		return err
+	}
+
+	for k, v := range sr.headers {
+		for _, value := range v {
+			w.Header().Add(k, value)
+		}
+	}
+	if opts.NDJSON {
+		w.Header().Set("Content-Type", ContentTypeNDJSON)
+	}
+	w.WriteHeader(sr.StatusCode)
+
+	sw := &streamWriter{w: w}
+	if flusher, isFlusher := w.(http.Flusher); isFlusher {
+		sw.flusher = flusher
+	}
+
+	if !opts.NDJSON {
+		head, err := json.Marshal(&StandardBody{Status: getStatus(sr.StatusCode), Message: sr.translatedMessage(), Code: sr.Code})
+		if err != nil {
+			return err
+		}
+		sw.write(bytes.TrimSuffix(head, []byte("}")))
+		sw.write([]byte(`,"data":[`))
+	}
+
+	for count := 0; ok; count++ {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return sw.interrupt(opts.NDJSON, err)
+		}
+
+		if opts.NDJSON {
+			sw.write(append(b, '\n'))
+		} else {
+			if count > 0 {
+				sw.write([]byte(","))
+			}
+			sw.write(b)
+		}
+		if sw.err != nil {
+			return sw.err
+		}
+
+		if (count+1)%flushEvery == 0 {
+			sw.flush()
+		}
+
+		if item, ok, err = items.Next(); err != nil {
+			return sw.interrupt(opts.NDJSON, err)
+		}
+	}
+
+	if !opts.NDJSON {
+		sw.write([]byte("]"))
+		if sr.pagination != nil {
+			b, err := json.Marshal(sr.pagination)
+			if err != nil {
+				return sw.interrupt(false, err)
+			}
+			sw.write([]byte(`,"pagination":`))
+			sw.write(b)
+		}
+		if links := sr.BuildLinks(r); links != nil {
+			b, err := json.Marshal(links)
+			if err != nil {
+				return sw.interrupt(false, err)
+			}
+			sw.write([]byte(`,"links":`))
+			sw.write(b)
+		}
+		sw.write([]byte("}"))
+	}
+
+	sw.flush()
+
+	return sw.err
+}
+
+// StreamFor streams a StandardResponse, using NDJSON when the request Accept header prefers it
+func StreamFor(w http.ResponseWriter, r *http.Request, sr *StandardResponse, items Iterator, opts *StreamOptions) error {
+	streamOpts := StreamOptions{}
+	if opts != nil {
+		streamOpts = *opts
+	}
+
+	for _, mr := range parseAccept(r.Header.Get("Accept")) {
+		if mr.q <= 0 {
+			continue
+		}
+		if mr.mediaType == ContentTypeNDJSON {
+			streamOpts.NDJSON = true
+			break
+		}
+		if mr.matches(ContentTypeJSON) {
+			break
+		}
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	return stream(w, r, sr, items, &streamOpts)
+}
+
+// streamWriter keeps the first write error and flushes when possible
+type streamWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	err     error
+}
+
+func (sw *streamWriter) write(b []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+func (sw *streamWriter) flush() {
+	if sw.err == nil && sw.flusher != nil {
+		sw.flusher.Flush()
+	}
+}
+
+// interrupt terminates a stream after a failure, returning the failure
+func (sw *streamWriter) interrupt(ndjson bool, err error) error {
+	if ndjson {
+		sw.write([]byte("{" + streamInterrupted + "}\n"))
+	} else {
+		sw.write([]byte("]," + streamInterrupted + "}"))
+	}
+	sw.flush()
+
+	return err
+}
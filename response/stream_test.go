@@ -0,0 +1,114 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sliceIterator yields the given items, then err if set
+func sliceIterator(items []interface{}, err error) Iterator {
+	i := 0
+	return IteratorFunc(func() (interface{}, bool, error) {
+		if i < len(items) {
+			i++
+			return items[i-1], true, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, false, nil
+	})
+}
+
+func TestStream(t *testing.T) {
+	items := []interface{}{map[string]int{"id": 1}, map[string]int{"id": 2}, map[string]int{"id": 3}}
+
+	sr := New()
+	sr.StatusCode = http.StatusOK
+	sr.Message = "export"
+	sr.AddHeader("X-Export", "events")
+
+	paging := sliceIterator(items, nil)
+	iter := IteratorFunc(func() (interface{}, bool, error) {
+		item, ok, err := paging.Next()
+		if !ok {
+			// pagination known only once the items are exhausted
+			sr.SetPagination(&Pagination{Limit: 3, TotalRecords: 3})
+		}
+		return item, ok, err
+	})
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, Stream(w, sr, iter, &StreamOptions{FlushEvery: 2}))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "events", w.Header().Get("X-Export"))
+	assert.Equal(t, ContentTypeJSON, w.Header().Get("Content-Type"))
+	assert.True(t, w.Flushed)
+	assert.Equal(t, `{"message":"export","status":"success","data":[{"id":1},{"id":2},{"id":3}],"pagination":{"offset":0,"limit":3,"totalRecords":3}}`, w.Body.String())
+
+	var body StandardBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), "valid StandardBody")
+}
+
+func TestStreamEmpty(t *testing.T) {
+	sr := New()
+	sr.StatusCode = http.StatusOK
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, Stream(w, sr, sliceIterator(nil, nil), nil))
+	assert.Equal(t, `{"message":"","status":"success","data":[]}`, w.Body.String())
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	sr := New()
+	sr.StatusCode = http.StatusOK
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/export", nil)
+	r.Header.Set("Accept", "application/x-ndjson, application/json;q=0.5")
+
+	assert.NoError(t, StreamFor(w, r, sr, sliceIterator([]interface{}{1, "two"}, nil), nil))
+	assert.Equal(t, []string{ContentTypeNDJSON}, w.Header()["Content-Type"])
+	assert.Equal(t, "1\n\"two\"\n", w.Body.String())
+}
+
+func TestStreamErrors(t *testing.T) {
+	failure := errors.New("cursor closed")
+	sr := New()
+	sr.StatusCode = http.StatusOK
+
+	// failing before the first item writes nothing
+	w := httptest.NewRecorder()
+	assert.Equal(t, failure, Stream(w, sr, sliceIterator(nil, failure), nil))
+	assert.Equal(t, 0, w.Body.Len())
+	assert.Empty(t, w.Header(), "headers are not written")
+
+	// failing midway terminates the envelope
+	w = httptest.NewRecorder()
+	assert.Equal(t, failure, Stream(w, sr, sliceIterator([]interface{}{1}, failure), nil))
+	assert.Equal(t, `{"message":"","status":"success","data":[1],"error":"stream interrupted"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	assert.Equal(t, failure, Stream(w, sr, sliceIterator([]interface{}{1}, failure), &StreamOptions{NDJSON: true}))
+	assert.Equal(t, "1\n{\"error\":\"stream interrupted\"}\n", w.Body.String())
+}
+
+func TestChannelIterator(t *testing.T) {
+	ch := make(chan interface{}, 2)
+	ch <- "a"
+	ch <- "b"
+	close(ch)
+
+	sr := New()
+	sr.StatusCode = http.StatusOK
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, Stream(w, sr, ChannelIterator(ch), nil))
+	assert.Equal(t, `{"message":"","status":"success","data":["a","b"]}`, w.Body.String())
+}
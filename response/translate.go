@@ -0,0 +1,35 @@
+package response
+
+// Translator translates the human readable texts of a response, usually to the request locale
+type Translator interface {
+	// Translate returns the translation of a message, or the message itself when it has none
+	Translate(message string) string
+	// TranslateValidation returns the message describing a validation failure, or "" when it has none
+	TranslateValidation(f ValidationFailure) string
+}
+
+// SetTranslator sets the translator of the message and validation failures of the response
+// Translation happens when the body is built, so Message and the validation failures may be
+// set before or after the translator.
+func (r *StandardResponse) SetTranslator(t Translator) {
+	r.translator = t
+}
+
+// translatedMessage returns the message of the response, translated when a translator is set
+func (r *StandardResponse) translatedMessage() string {
+	if r.translator == nil || r.Message == "" {
+		return r.Message
+	}
+	return r.translator.Translate(r.Message)
+}
+
+// validationResponses returns the validation failures of the response, with their messages when a translator is set
+func (r *StandardResponse) validationResponses() *ValidationResponses {
+	responses := prepareValidationResponses(r.validationFailures)
+	if r.translator != nil {
+		for i, f := range r.validationFailures {
+			(*responses)[i].Message = r.translator.TranslateValidation(f)
+		}
+	}
+	return responses
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"net/http"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/metrics"
+	mw "bitbucket.org/teachingstrategies/go-svc-bootstrap/middlewares"
+)
+
+// Middleware names the middlewares of the stack built by New, to exclude or replace them
+type Middleware string
+
+// Middlewares of the stack built by New, in order
+const (
+	PanicGuard   Middleware = "panic_guard"
+	Heartbeat    Middleware = "heartbeat"
+	Metrics      Middleware = "metrics"
+	StripSlashes Middleware = "strip_slashes"
+	Timeout      Middleware = "timeout"
+	BodyLimit    Middleware = "body_limit"
+	Context      Middleware = "context"
+	RequestID    Middleware = "request_id"
+	Profiling    Middleware = "profiling"
+	Logging      Middleware = "logging"
+	Compression  Middleware = "compression"
+	Recoverer    Middleware = "recoverer"
+	ContentType  Middleware = "content_type"
+)
+
+// DefaultTimeout is the request timeout of the stack built by New
+const DefaultTimeout = 600 * time.Second
+
+// DefaultHeartbeatPath is the uptime monitor endpoint of the stack built by New
+const DefaultHeartbeatPath = "/ping"
+
+// Option configures the router built by New
+type Option func(*config)
+
+type config struct {
+	timeout       time.Duration
+	heartbeatPath string
+	bodyLimit     int64
+	contextFields map[string]interface{}
+	excluded      map[Middleware]bool
+	replaced      map[Middleware]func(http.Handler) http.Handler
+	extra         []func(http.Handler) http.Handler
+	metrics       *metrics.Metrics
+	tracer        apm.Tracer
+	logging       *mw.LoggingOptions
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		timeout:       DefaultTimeout,
+		heartbeatPath: DefaultHeartbeatPath,
+		contextFields: map[string]interface{}{},
+		excluded:      map[Middleware]bool{},
+		replaced:      map[Middleware]func(http.Handler) http.Handler{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithTimeout sets the request timeout, zero disables it
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// WithHeartbeat sets the path of the uptime monitor endpoint, empty disables it
+func WithHeartbeat(path string) Option {
+	return func(c *config) {
+		c.heartbeatPath = path
+	}
+}
+
+// WithBodyLimit limits the size of request bodies, in bytes
+// Reading past the limit fails, see http.MaxBytesReader. There is no limit by default.
+func WithBodyLimit(maxBytes int64) Option {
+	return func(c *config) {
+		c.bodyLimit = maxBytes
+	}
+}
+
+// WithContextFields adds fields to the request context under utils.ContextKey, alongside "env"
+func WithContextFields(fields map[string]interface{}) Option {
+	return func(c *config) {
+		for k, v := range fields {
+			c.contextFields[k] = v
+		}
+	}
+}
+
+// WithRecoverer replaces the panic recoverer
+func WithRecoverer(recoverer func(http.Handler) http.Handler) Option {
+	return ReplaceMiddleware(Recoverer, recoverer)
+}
+
+// WithoutMiddlewares removes middlewares from the stack
+func WithoutMiddlewares(names ...Middleware) Option {
+	return func(c *config) {
+		for _, name := range names {
+			c.excluded[name] = true
+		}
+	}
+}
+
+// ReplaceMiddleware replaces a middleware of the stack, keeping its position
+func ReplaceMiddleware(name Middleware, mw func(http.Handler) http.Handler) Option {
+	return func(c *config) {
+		c.replaced[name] = mw
+	}
+}
+
+// WithMiddlewares appends middlewares to the end of the stack
+func WithMiddlewares(mws ...func(http.Handler) http.Handler) Option {
+	return func(c *config) {
+		c.extra = append(c.extra, mws...)
+	}
+}
+
+// WithMetrics records the HTTP metrics of every request but heartbeats
+// The metrics are served once mounted with MountMetrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(c *config) {
+		c.metrics = m
+	}
+}
+
+// WithTracer traces requests with an APM tracer instead of the NewRelic application given to New
+// Use apm.Multi to report to NewRelic and OpenTelemetry alike.
+func WithTracer(t apm.Tracer) Option {
+	return func(c *config) {
+		c.tracer = t
+	}
+}
+
+// WithLogging configures the access logs, see middleware.LoggingOptions
+// The isLocal argument of New is ignored for access logs, set LoggingOptions.PanicStdout instead.
+func WithLogging(opts mw.LoggingOptions) Option {
+	return func(c *config) {
+		c.logging = &opts
+	}
+}
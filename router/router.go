@@ -1,8 +1,10 @@
 package router
 
 import (
-	"time"
+	"net/http"
 
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/metrics"
 	mw "bitbucket.org/teachingstrategies/go-svc-bootstrap/middlewares"
 	"bitbucket.org/teachingstrategies/go-svc-bootstrap/tsjwt"
 	newrelic "github.com/newrelic/go-agent"
@@ -18,48 +20,110 @@ import (
 // which will ALWAYS run,
 // regardless of subrouted path on the router.
 // Middlewares added with this function:
+//   * Recover (safety net for the panics of the middlewares below, answered with a 500)
 //   * Heartbeat (/ping)
-//   * Recoverer
+//   * Metrics (only when configured with WithMetrics, served by MountMetrics)
 //   * StripSlashes
 //   * Timeout (600 seconds)
+//   * LimitBody (only when configured with WithBodyLimit)
 //   * AddContext (adds env key to context)
-//   * AddProfiling (NewRelic profiling)
 //   * RequestID
-//   * AddLogging
+//   * AddTracing (NewRelic profiling, or the tracer of WithTracer)
+//   * AddLogging (configured with WithLogging)
+//   * AddCompression (br, gzip or deflate above 1KB)
+//   * Recover (500 StandardResponse on panics)
 //   * SetContentType (application/json)
-func New(zlog *zerolog.Logger, apm newrelic.Application, env string, isLocal bool) *chi.Mux {
+// The stack can be tuned with options, e.g. WithTimeout, WithoutMiddlewares or ReplaceMiddleware.
+func New(zlog *zerolog.Logger, app newrelic.Application, env string, isLocal bool, opts ...Option) *chi.Mux {
 
-	r := chi.NewRouter()
-
-	// Router Middleware
+	c := newConfig(opts)
 
-	// Uptime monitor endpoint
-	// https://godoc.org/github.com/go-chi/chi/middleware#Heartbeat
-	r.Use(middleware.Heartbeat("/ping"))
-	// This recovers from panics, ensuring that logging/metric collection is not lost
-	r.Use(mw.Recoverer)
-	// This standardizes request paths
-	r.Use(middleware.StripSlashes)
-	// This gives us a base timeout for requests
-	r.Use(middleware.Timeout(time.Second * time.Duration(600)))
+	r := chi.NewRouter()
 
 	var ctxs = make(map[string]interface{})
+	for k, v := range c.contextFields {
+		ctxs[k] = v
+	}
 	ctxs["env"] = env
 
-	r.Use(mw.AddContext(ctxs))
-	// Metrics via NewRelic Integration
-	r.Use(mw.AddProfiling(apm))
-	// Adds a request id to our context so that we
-	// can piece together requests
-	r.Use(middleware.RequestID)
-	// Log the access
-	r.Use(mw.AddLogging(zlog, isLocal))
-	// This is a JSON API, thus set that content type for everything
-	r.Use(render.SetContentType(render.ContentTypeJSON))
+	logging := mw.LoggingOptions{PanicStdout: isLocal}
+	if c.logging != nil {
+		logging = *c.logging
+	}
+
+	tracer := c.tracer
+	if tracer == nil {
+		tracer = apm.NewRelic(app)
+	}
+
+	stack := []struct {
+		name Middleware
+		mw   func(http.Handler) http.Handler
+	}{
+		// This recovers from the panics of the middlewares running before Recoverer, which do not
+		// reach the APM, so that net/http does not drop the connection
+		{PanicGuard, mw.Recover(zlog)},
+		// Uptime monitor endpoint
+		// https://godoc.org/github.com/go-chi/chi/middleware#Heartbeat
+		{Heartbeat, optional(c.heartbeatPath != "", func() func(http.Handler) http.Handler { return middleware.Heartbeat(c.heartbeatPath) })},
+		// RED metrics, recording the 500s of recovered panics too, including those answered by PanicGuard
+		{Metrics, optional(c.metrics != nil, func() func(http.Handler) http.Handler { return c.metrics.Middleware })},
+		// This standardizes request paths
+		{StripSlashes, middleware.StripSlashes},
+		// This gives us a base timeout for requests
+		{Timeout, optional(c.timeout > 0, func() func(http.Handler) http.Handler { return middleware.Timeout(c.timeout) })},
+		// This bounds the memory used by request bodies
+		{BodyLimit, optional(c.bodyLimit > 0, func() func(http.Handler) http.Handler { return mw.LimitBody(c.bodyLimit) })},
+		{Context, mw.AddContext(ctxs)},
+		// Adds a request id to our context so that we
+		// can piece together requests
+		{RequestID, middleware.RequestID},
+		// Metrics via NewRelic Integration, or OpenTelemetry traces, carrying the request id
+		{Profiling, mw.AddTracing(tracer)},
+		// Log the access
+		{Logging, mw.AddAccessLogging(zlog, logging)},
+		// Compress responses large enough to benefit from it
+		{Compression, mw.AddCompression(mw.DefaultCompressionThreshold)},
+		// This recovers from panics with a 500 StandardResponse, logged with the request id and
+		// noticed on the APM, so that logging/metric collection see a regular response
+		{Recoverer, mw.Recover(zlog)},
+		// This is a JSON API, thus set that content type for everything
+		{ContentType, render.SetContentType(render.ContentTypeJSON)},
+	}
+
+	for _, m := range stack {
+		handler := m.mw
+		if replacement, ok := c.replaced[m.name]; ok {
+			handler = replacement
+		}
+		if c.excluded[m.name] || handler == nil {
+			continue
+		}
+		r.Use(handler)
+	}
+
+	for _, m := range c.extra {
+		r.Use(m)
+	}
 
 	return r
 }
 
+// MountMetrics serves the metrics of m on metrics.DefaultPath
+// Routes can only be added to a mux once all its middlewares are, so it must be called after
+// the last r.Use, e.g. AddJWTAuth.
+func MountMetrics(r chi.Router, m *metrics.Metrics) {
+	r.Method(http.MethodGet, metrics.DefaultPath, m.Handler())
+}
+
+// optional returns the middleware built by fn when enabled, nil otherwise
+func optional(enabled bool, fn func() func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	if !enabled {
+		return nil
+	}
+	return fn()
+}
+
 // AddJWTAuth creates a JWT Authenticator and if validates, add it to the context
 func AddJWTAuth(r *chi.Mux, jwtVerifyKey string) *chi.Mux {
 	// Create a JWT Authenticator based on our signature
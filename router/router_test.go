@@ -0,0 +1,148 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/metrics"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
+	newrelic "github.com/newrelic/go-agent"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newApp(t *testing.T) newrelic.Application {
+	config := newrelic.NewConfig("router-test", "")
+	config.Enabled = false
+	app, err := newrelic.NewApplication(config)
+	assert.NoError(t, err)
+	return app
+}
+
+func TestNewDefaults(t *testing.T) {
+	logger := zerolog.Nop()
+	r := New(&logger, newApp(t), "test", false)
+	r.Get("/env", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Context().Value(utils.ContextKey("env")).(string)))
+	})
+	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/env/", nil))
+	assert.Equal(t, "test", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"message":"Internal Server Error","status":"server error"}`, w.Body.String())
+
+	assert.Equal(t, 11, len(r.Middlewares()), "body limit is off by default")
+}
+
+func TestNewOptions(t *testing.T) {
+	logger := zerolog.Nop()
+	recovered := false
+
+	r := New(&logger, newApp(t), "test", false,
+		WithHeartbeat("/health/live"),
+		WithTimeout(time.Second),
+		WithBodyLimit(8),
+		WithContextFields(map[string]interface{}{"service": "planning"}),
+		WithRecoverer(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer func() {
+					if recover() != nil {
+						recovered = true
+						w.WriteHeader(http.StatusTeapot)
+					}
+				}()
+				next.ServeHTTP(w, r)
+			})
+		}),
+		WithoutMiddlewares(Compression, Logging),
+		WithMiddlewares(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Extra", "1")
+				next.ServeHTTP(w, r)
+			})
+		}),
+	)
+	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	r.Post("/body", func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 16)
+		_, err := r.Body.Read(buf)
+		assert.Error(t, err, "body is limited")
+		w.Write([]byte(r.Context().Value(utils.ContextKey("service")).(string)))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/health/live", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	assert.True(t, recovered)
+	assert.Equal(t, http.StatusTeapot, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/body", strings.NewReader("a body longer than 8 bytes")))
+	assert.Equal(t, "planning", w.Body.String())
+	assert.Equal(t, "1", w.Header().Get("X-Extra"))
+}
+
+func TestNewMiddlewarePanic(t *testing.T) {
+	logger := zerolog.Nop()
+	m := metrics.New(metrics.Config{SkipRuntimeCollectors: true})
+	r := New(&logger, newApp(t), "test", false, WithMetrics(m),
+		ReplaceMiddleware(StripSlashes, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+		}),
+	)
+	MountMetrics(r, m)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	assert.NotPanics(t, func() { r.ServeHTTP(w, httptest.NewRequest("GET", "/users/1", nil)) })
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, `{"message":"Internal Server Error","status":"server error"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `http_requests_total{code="500",method="GET",route="unmatched"} 1`)
+}
+
+func TestNewWithMetrics(t *testing.T) {
+	logger := zerolog.Nop()
+	m := metrics.New(metrics.Config{SkipRuntimeCollectors: true})
+	r := New(&logger, newApp(t), "test", false, WithMetrics(m))
+
+	// middlewares may still be added after New
+	assert.NotPanics(t, func() { AddJWTAuth(r, "secret") })
+
+	MountMetrics(r, m)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `http_requests_total{code="200",method="GET",route="/users/{id}"} 1`)
+	assert.NotContains(t, w.Body.String(), "/ping")
+}
@@ -0,0 +1,255 @@
+// Package server runs an http.Handler, usually the *chi.Mux of router.New, with a graceful shutdown:
+// on SIGTERM or SIGINT the service is marked as not ready, in-flight requests are drained,
+// then registered resources are closed and New Relic data is flushed.
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/health"
+	newrelic "github.com/newrelic/go-agent"
+	"github.com/rs/zerolog"
+)
+
+// Defaults of Config
+const (
+	DefaultAddr               = ":8080"
+	DefaultReadHeaderTimeout  = 10 * time.Second
+	DefaultReadTimeout        = 30 * time.Second
+	DefaultIdleTimeout        = 120 * time.Second
+	DefaultDrainDelay         = 5 * time.Second
+	DefaultShutdownTimeout    = 30 * time.Second
+	DefaultAPMShutdownTimeout = 10 * time.Second
+)
+
+// Config configures a Server
+type Config struct {
+	// Addr is the TCP address to listen on (default DefaultAddr)
+	Addr string
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout configure the http.Server
+	// WriteTimeout is unset by default, as router.New already times out requests.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// DrainDelay is the time between marking the service as not ready and stopping the listener,
+	// for load balancers to notice (default DefaultDrainDelay)
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds the wait for in-flight requests (default DefaultShutdownTimeout)
+	ShutdownTimeout time.Duration
+
+	// Health is marked as not ready when shutting down
+	Health *health.HealthCheckCollection
+	// APM is flushed when shutting down, unless Tracer is set
+	APM newrelic.Application
+	// Tracer is flushed when shutting down instead of APM, e.g. the tracer passed to router.WithTracer
+	// A tracer built from APM with apm.NewRelic already flushes it, tracers reporting elsewhere
+	// must be combined with it, e.g. apm.Multi(apm.NewRelic(app), otelTracer), for both to be flushed.
+	Tracer apm.Tracer
+	// APMShutdownTimeout bounds the flush of APM data (default DefaultAPMShutdownTimeout)
+	APMShutdownTimeout time.Duration
+
+	// Logger logs the lifecycle of the server
+	Logger *zerolog.Logger
+}
+
+// Closer is a resource closed on shutdown, such as *postgres.DB
+type Closer interface {
+	Close()
+}
+
+// Server is an HTTP server with a graceful shutdown
+type Server struct {
+	config Config
+	http   *http.Server
+	logger zerolog.Logger
+
+	mu       sync.Mutex
+	closers  []closer
+	shutdown sync.Once
+	err      error
+}
+
+type closer struct {
+	name string
+	fn   func() error
+}
+
+// New creates a Server for handler
+func New(handler http.Handler, config Config) *Server {
+	if config.Addr == "" {
+		config.Addr = DefaultAddr
+	}
+	if config.ReadHeaderTimeout == 0 {
+		config.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = DefaultReadTimeout
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+	if config.DrainDelay == 0 {
+		config.DrainDelay = DefaultDrainDelay
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if config.APMShutdownTimeout == 0 {
+		config.APMShutdownTimeout = DefaultAPMShutdownTimeout
+	}
+
+	logger := zerolog.Nop()
+	if config.Logger != nil {
+		logger = *config.Logger
+	}
+
+	return &Server{
+		config: config,
+		logger: logger,
+		http: &http.Server{
+			Addr:              config.Addr,
+			Handler:           handler,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			ReadTimeout:       config.ReadTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+		},
+	}
+}
+
+// OnShutdown registers a function called on shutdown, once requests are drained
+// Functions are called in reverse order of registration, so resources opened last are closed first.
+func (s *Server) OnShutdown(name string, fn func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closers = append(s.closers, closer{name: name, fn: fn})
+}
+
+// CloseOnShutdown registers a resource closed on shutdown, see OnShutdown
+func (s *Server) CloseOnShutdown(name string, c Closer) {
+	s.OnShutdown(name, func() error {
+		c.Close()
+		return nil
+	})
+}
+
+// ListenAndServe serves requests until SIGTERM or SIGINT is received, then shuts down gracefully
+//
+// Return Values:
+//     1st: An error representing failure to listen, or the first failure to shut down
+func (s *Server) ListenAndServe() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	return s.Run(ctx)
+}
+
+// Run serves requests until ctx is done, then shuts down gracefully
+//
+// Return Values:
+//     1st: An error representing failure to listen, or the first failure to shut down
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves requests on listener until ctx is done, then shuts down gracefully
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	errs := make(chan error, 1)
+	go func() {
+		s.logger.Info().Str("addr", listener.Addr().String()).Msg("server started")
+		errs <- s.http.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		// stopped by a direct call to Shutdown, or failing on its own, resources still need closing
+		shutdownErr := s.Shutdown(context.Background())
+		if err == http.ErrServerClosed {
+			return shutdownErr
+		}
+		return err
+	case <-ctx.Done():
+		s.logger.Info().Msg("shutdown requested")
+	}
+
+	return s.Shutdown(context.Background())
+}
+
+// Shutdown stops the server gracefully, only once
+// The service is marked as not ready and given DrainDelay for load balancers to stop routing to it,
+// then in-flight requests are given ShutdownTimeout to complete. Registered resources are closed
+// and the tracer, or the APM application without one, is flushed even when draining fails.
+//
+// Return Values:
+//     1st: The first failure to drain requests or to close a resource
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdown.Do(func() {
+		s.err = s.doShutdown(ctx)
+	})
+	return s.err
+}
+
+func (s *Server) doShutdown(ctx context.Context) error {
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+
+	if s.config.Health != nil {
+		s.config.Health.SetReady(false)
+
+		s.logger.Info().Dur("delay", s.config.DrainDelay).Msg("draining")
+		select {
+		case <-time.After(s.config.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, s.config.ShutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(shutdownCtx); err != nil {
+		s.logger.Error().Err(err).Msg("unable to drain requests")
+		fail(err)
+	}
+
+	s.mu.Lock()
+	closers := s.closers
+	s.mu.Unlock()
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].fn(); err != nil {
+			s.logger.Error().Err(err).Str("resource", closers[i].name).Msg("unable to close resource")
+			fail(err)
+		}
+	}
+
+	// the tracer usually wraps the APM application, which must only be shut down once
+	if s.config.Tracer != nil {
+		s.config.Tracer.Shutdown(s.config.APMShutdownTimeout)
+	} else if s.config.APM != nil {
+		s.config.APM.Shutdown(s.config.APMShutdownTimeout)
+	}
+
+	s.logger.Info().Msg("server stopped")
+
+	return first
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/health"
+	newrelic "github.com/newrelic/go-agent"
+	"github.com/stretchr/testify/assert"
+)
+
+type resource struct {
+	name   string
+	closed *[]string
+}
+
+func (r resource) Close() {
+	*r.closed = append(*r.closed, r.name)
+}
+
+// countingApp counts the shutdowns of a New Relic application
+type countingApp struct {
+	newrelic.Application
+	shutdowns int
+}
+
+func (a *countingApp) Shutdown(time.Duration) { a.shutdowns++ }
+
+func TestShutdownFlushesAPMOnce(t *testing.T) {
+	app := &countingApp{}
+	s := New(http.NewServeMux(), Config{APM: app, Tracer: apm.NewRelic(app)})
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, 1, app.shutdowns, "through the tracer only")
+
+	app = &countingApp{}
+	s = New(http.NewServeMux(), Config{APM: app})
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, 1, app.shutdowns, "without tracer")
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	hcc := health.NewHealthCheckCollection()
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	s := New(mux, Config{Health: hcc, DrainDelay: 20 * time.Millisecond, ShutdownTimeout: time.Second})
+
+	var closed []string
+	s.CloseOnShutdown("db", resource{"db", &closed})
+	s.CloseOnShutdown("cache", resource{"cache", &closed})
+	failure := errors.New("flush failed")
+	s.OnShutdown("queue", func() error { return failure })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, listener) }()
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		body <- string(b)
+	}()
+
+	<-started
+	cancel()
+
+	// readiness flips before the listener stops
+	for deadline := time.Now().Add(time.Second); hcc.IsReady() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.False(t, hcc.IsReady())
+	close(release)
+
+	assert.Equal(t, "done", <-body, "in-flight request completes")
+	assert.Equal(t, failure, <-served)
+	assert.Equal(t, []string{"cache", "db"}, closed, "resources closed in reverse order")
+
+	_, err = http.Get("http://" + listener.Addr().String() + "/slow")
+	assert.Error(t, err, "listener is closed")
+}
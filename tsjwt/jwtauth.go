@@ -12,7 +12,10 @@ import (
 	"strings"
 	"time"
 
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/apm"
+	"bitbucket.org/teachingstrategies/go-svc-bootstrap/utils"
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/rs/zerolog"
 )
 
 // Context keys
@@ -96,12 +99,32 @@ func Verify(ja *JWTAuth, findTokenFns ...func(r *http.Request) string) func(http
 			ctx := r.Context()
 			token, err := VerifyRequest(ja, r, findTokenFns...)
 			ctx = NewContext(ctx, token, err)
+			if claims, ok := verifiedClaims(token, err); ok {
+				txn := apm.FromContext(ctx)
+				txn.AddAttribute(apm.AttributeUserID, claims.UserID)
+				txn.AddAttribute(apm.AttributeVisitorID, claims.VisitorID)
+
+				if logger, ok := utils.LoggerFromContext(ctx); ok {
+					logger.UpdateContext(func(c zerolog.Context) zerolog.Context {
+						return c.Int64("uid", claims.UserID).Int64("vid", claims.VisitorID)
+					})
+				}
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		}
 		return http.HandlerFunc(hfn)
 	}
 }
 
+// verifiedClaims returns the claims of a valid token
+func verifiedClaims(token *jwt.Token, err error) (*JWT, bool) {
+	if err != nil || token == nil {
+		return nil, false
+	}
+	claims, ok := token.Claims.(*JWT)
+	return claims, ok
+}
+
 func VerifyRequest(ja *JWTAuth, r *http.Request, findTokenFns ...func(r *http.Request) string) (*jwt.Token, error) {
 	var tokenStr string
 	var err error
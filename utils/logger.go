@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// loggerKey is the context key of the request scoped logger
+type loggerKey struct{}
+
+// NewLoggerContext returns a context carrying a request scoped logger
+// The logger is also retrievable with zerolog.Ctx.
+func NewLoggerContext(ctx context.Context, logger *zerolog.Logger) context.Context {
+	return context.WithValue(logger.WithContext(ctx), loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the request scoped logger of ctx, as put there by middleware.AddLogging
+// Its entries carry the request id, the user and visitor ids, and the route of the request.
+//
+// Return Values:
+//     1st: The request logger, or a disabled logger when there is none
+//     2nd: Whether ctx carries a request logger
+func LoggerFromContext(ctx context.Context) (*zerolog.Logger, bool) {
+	if logger, ok := ctx.Value(loggerKey{}).(*zerolog.Logger); ok && logger != nil {
+		return logger, true
+	}
+	nop := zerolog.Nop()
+	return &nop, false
+}
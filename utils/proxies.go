@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"net"
+	"strings"
+)
+
+// ParseTrustedProxies parses the IPs or CIDR ranges of trusted proxies, ignoring invalid entries
+// A single IP is trusted as a range of one address.
+func ParseTrustedProxies(proxies []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// IsTrustedProxy reports whether ip belongs to one of the trusted ranges
+func IsTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTrustedRemote reports whether the remote address of a request, with or without its port,
+// belongs to one of the trusted ranges
+func IsTrustedRemote(remoteAddr string, trusted []*net.IPNet) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && IsTrustedProxy(ip, trusted)
+}